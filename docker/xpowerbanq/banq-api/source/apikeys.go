@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiTier configures the limits of the API keys of a tier; unset limits
+// default to those of anonymous clients
+type apiTier struct {
+	MaxRows   int      `yaml:"max-rows"`   // rows per query (default: maxRows)
+	RateLimit float64  `yaml:"rate-limit"` // tokens per second (default: rateLimit)
+	RateBurst int      `yaml:"rate-burst"` // tokens (default: rateBurst)
+	Routes    []string `yaml:"routes"`     // endpoint names, e.g. ohlc (default: all)
+}
+
+// apiKeyFile is the YAML format of the API key file
+type apiKeyFile struct {
+	Tiers map[string]*apiTier `yaml:"tiers"`
+	Keys  []struct {
+		Key  string `yaml:"key"`
+		Name string `yaml:"name"`
+		Tier string `yaml:"tier"`
+	} `yaml:"keys"`
+}
+
+// apiClient is the (partner) client an API key belongs to
+type apiClient struct {
+	name   string
+	tier   *apiTier
+	routes map[string]bool // allowed endpoint names (nil: all)
+}
+
+// apiClientKey is the context key of the API client of a request
+type apiClientKey struct{}
+
+// minAPIKeyLength is the minimum length of API keys
+const minAPIKeyLength = 16
+
+var (
+	// API clients by key (nil: API keys disabled) and the key file they
+	// were loaded from (to detect changes)
+	apiKeys     map[string]*apiClient
+	apiKeysFile os.FileInfo
+	apiKeysMux  sync.RWMutex
+
+	// invalidClient marks requests with an unknown API key
+	invalidClient = &apiClient{}
+)
+
+// loadAPIKeys reads and validates an API key file
+func loadAPIKeys(path string) (map[string]*apiClient, os.FileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read API key file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read API key file: %v", err)
+	}
+
+	var file apiKeyFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("invalid API key file %s: %v", path, err)
+	}
+
+	for name, tier := range file.Tiers {
+		if tier == nil {
+			return nil, nil, fmt.Errorf("missing limits of tier %s in API key file %s", name, path)
+		}
+		if tier.MaxRows < 0 || tier.RateLimit < 0 || tier.RateBurst < 0 {
+			return nil, nil, fmt.Errorf("negative limit of tier %s in API key file %s", name, path)
+		}
+		for _, route := range tier.Routes {
+			if _, exists := endpointRoutes["/"+route+".json"]; !exists {
+				if _, exists := endpointRoutes["/"+route]; !exists {
+					return nil, nil, fmt.Errorf("unknown route %s of tier %s in API key file %s", route, name, path)
+				}
+			}
+		}
+	}
+
+	clients := make(map[string]*apiClient, len(file.Keys))
+	for i, entry := range file.Keys {
+		if len(entry.Key) < minAPIKeyLength {
+			return nil, nil, fmt.Errorf("key %d in API key file %s is shorter than %d characters", i+1, path, minAPIKeyLength)
+		}
+		if entry.Name == "" {
+			return nil, nil, fmt.Errorf("missing name of key %d in API key file %s", i+1, path)
+		}
+		if _, exists := clients[entry.Key]; exists {
+			return nil, nil, fmt.Errorf("duplicate key %d (%s) in API key file %s", i+1, entry.Name, path)
+		}
+		tier, exists := file.Tiers[entry.Tier]
+		if !exists {
+			return nil, nil, fmt.Errorf("unknown tier %q of key %d (%s) in API key file %s", entry.Tier, i+1, entry.Name, path)
+		}
+
+		client := &apiClient{name: entry.Name, tier: tier}
+		if len(tier.Routes) > 0 {
+			client.routes = make(map[string]bool, len(tier.Routes))
+			for _, route := range tier.Routes {
+				client.routes[route] = true
+			}
+		}
+		clients[entry.Key] = client
+	}
+
+	return clients, info, nil
+}
+
+// reloadAPIKeys reloads the API key file if it changed (or if forced); the
+// previous keys are kept if the file is invalid
+func reloadAPIKeys(path string, force bool) error {
+	if info, err := os.Stat(path); err == nil && !force {
+		apiKeysMux.RLock()
+		loaded := apiKeysFile
+		apiKeysMux.RUnlock()
+		if loaded != nil && os.SameFile(loaded, info) &&
+			loaded.ModTime().Equal(info.ModTime()) && loaded.Size() == info.Size() {
+			return nil
+		}
+	}
+
+	clients, info, err := loadAPIKeys(path)
+	if err != nil {
+		return err
+	}
+
+	apiKeysMux.Lock()
+	apiKeys, apiKeysFile = clients, info
+	apiKeysMux.Unlock()
+	slog.Info("API keys loaded", "path", path, "keys", len(clients), "clients", clientNames(clients))
+	return nil
+}
+
+// clientNames returns the sorted distinct names of API clients
+func clientNames(clients map[string]*apiClient) []string {
+	seen := make(map[string]bool, len(clients))
+	names := make([]string, 0, len(clients))
+	for _, client := range clients {
+		if !seen[client.name] {
+			seen[client.name] = true
+			names = append(names, client.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// watchAPIKeys reloads the API key file when it changes (checked every
+// interval; zero disables polling) or on a reload signal (e.g. SIGHUP)
+func watchAPIKeys(ctx context.Context, path string, interval time.Duration, reload <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			err = reloadAPIKeys(path, false)
+		case <-reload:
+			slog.Info("Reloading API keys", "path", path)
+			err = reloadAPIKeys(path, true)
+		}
+		if err != nil {
+			slog.Error("API key reload failed (keeping previous keys)", "err", err)
+		}
+	}
+}
+
+// apiKeyFrom returns the API key of a request from the X-API-Key header or
+// the api_key query parameter
+func apiKeyFrom(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// apiKeyMiddleware attaches the API client of a request's key (if any) to
+// its context; unknown keys are rejected by the API routes only, so that
+// health checks are unaffected
+func apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKeysMux.RLock()
+		clients := apiKeys
+		apiKeysMux.RUnlock()
+		if clients == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Responses depend on the key, so shared caches must tell them apart
+		w.Header().Add("Vary", "X-API-Key")
+
+		key := apiKeyFrom(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, exists := clients[key]
+		if !exists {
+			client = invalidClient
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, client)))
+	})
+}
+
+// requestClient returns the API client of a request (nil if anonymous)
+func requestClient(r *http.Request) *apiClient {
+	client, _ := r.Context().Value(apiClientKey{}).(*apiClient)
+	return client
+}
+
+// apiAccessMiddleware rejects requests with an unknown API key (401) or with
+// a key whose tier does not include the route (403); routes without a name
+// (e.g. the API docs) are open to all tiers
+func apiAccessMiddleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := requestClient(r)
+			switch {
+			case client == invalidClient:
+				writeError(w, "Invalid API key", http.StatusUnauthorized)
+			case client != nil && client.routes != nil && route != "" && !client.routes[route]:
+				writeError(w, "Route not allowed for API key", http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// routeName returns the endpoint name of a route suffix (e.g. /ohlc.json ->
+// ohlc), as used by the routes of API key tiers
+func routeName(suffix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(suffix, "/"), ".json")
+}
+
+// rowLimit returns the maximum number of rows per query of a request, which
+// is raised by API key tiers
+func rowLimit(r *http.Request) int {
+	if client := requestClient(r); client != nil && client.tier != nil && client.tier.MaxRows > 0 {
+		return client.tier.MaxRows
+	}
+	return maxRows
+}
+
+// clientRateLimit returns the rate limit bucket, rate and burst of a request:
+// per API client for keyed requests, otherwise per client IP
+func clientRateLimit(r *http.Request) (string, float64, int) {
+	client := requestClient(r)
+	if client == nil || client.tier == nil {
+		return clientIP(r), rateLimit, rateBurst
+	}
+
+	rate, burst := rateLimit, rateBurst
+	if client.tier.RateLimit > 0 {
+		rate = client.tier.RateLimit
+	}
+	if client.tier.RateBurst > 0 {
+		burst = client.tier.RateBurst
+	}
+	return "key:" + client.name, rate, burst
+}
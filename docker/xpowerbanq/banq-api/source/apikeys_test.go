@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// testAPIKeys is an API key file with an unrestricted and a restricted tier
+const testAPIKeys = `
+tiers:
+  partner:
+    max-rows: 3
+    rate-limit: 50
+    rate-burst: 20
+  latest:
+    routes: [latest]
+keys:
+  - key: partner-key-0123456789
+    name: acme
+    tier: partner
+  - key: latest-key-0123456789
+    name: ticker
+    tier: latest
+`
+
+// restoreAPIKeys restores the loaded API keys after the test
+func restoreAPIKeys(t *testing.T) {
+	origAPIKeys, origAPIKeysFile := apiKeys, apiKeysFile
+	t.Cleanup(func() {
+		apiKeysMux.Lock()
+		apiKeys, apiKeysFile = origAPIKeys, origAPIKeysFile
+		apiKeysMux.Unlock()
+	})
+}
+
+// writeAPIKeyFile writes an API key file into a temporary directory
+func writeAPIKeyFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "api-keys.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	clients, info, err := loadAPIKeys(writeAPIKeyFile(t, testAPIKeys))
+	if err != nil {
+		t.Fatalf("loadAPIKeys failed: %v", err)
+	}
+	if info == nil || len(clients) != 2 {
+		t.Fatalf("expected 2 clients, got %d", len(clients))
+	}
+
+	partner := clients["partner-key-0123456789"]
+	if partner.name != "acme" || partner.tier.MaxRows != 3 || partner.routes != nil {
+		t.Errorf("unexpected partner client %+v", partner)
+	}
+	ticker := clients["latest-key-0123456789"]
+	if !ticker.routes["latest"] || ticker.routes["ohlc"] {
+		t.Errorf("unexpected routes of restricted client %v", ticker.routes)
+	}
+}
+
+func TestLoadAPIKeysErrors(t *testing.T) {
+	tiers := "tiers:\n  basic: {}\n"
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"invalid YAML", "tiers: [\n", "invalid API key file"},
+		{"unknown field", "tiers:\n  basic:\n    max-row: 10\n", "invalid API key file"},
+		{"negative limit", "tiers:\n  basic:\n    rate-limit: -1\n", "negative limit of tier basic"},
+		{"unknown route", "tiers:\n  basic:\n    routes: [weekly]\n", "unknown route weekly"},
+		{"short key", tiers + "keys:\n  - {key: short, name: a, tier: basic}\n", "shorter than 16"},
+		{"missing name", tiers + "keys:\n  - {key: key-0123456789abcdef, tier: basic}\n", "missing name of key 1"},
+		{"unknown tier", tiers + "keys:\n  - {key: key-0123456789abcdef, name: a, tier: gold}\n", `unknown tier "gold"`},
+		{"duplicate key", tiers + "keys:\n  - {key: key-0123456789abcdef, name: a, tier: basic}\n" +
+			"  - {key: key-0123456789abcdef, name: b, tier: basic}\n", "duplicate key 2 (b)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadAPIKeys(writeAPIKeyFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, _, err := loadAPIKeys("/nonexistent/api-keys.yaml"); err == nil {
+		t.Error("expected error for missing API key file")
+	}
+}
+
+func TestReloadAPIKeys(t *testing.T) {
+	restoreAPIKeys(t)
+
+	path := writeAPIKeyFile(t, testAPIKeys)
+	if err := reloadAPIKeys(path, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed files are reloaded
+	updated := strings.Replace(testAPIKeys, "name: acme", "name: acme-corp", 1)
+	if err := os.WriteFile(path, []byte(updated), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if err := reloadAPIKeys(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if name := apiKeys["partner-key-0123456789"].name; name != "acme-corp" {
+		t.Errorf("expected reloaded name acme-corp, got %s", name)
+	}
+
+	// Invalid files keep the previous keys
+	if err := os.WriteFile(path, []byte("keys: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadAPIKeys(path, true); err == nil {
+		t.Error("expected error for invalid API key file")
+	}
+	if len(apiKeys) != 2 {
+		t.Errorf("expected previous keys to be kept, got %d", len(apiKeys))
+	}
+}
+
+func TestAPIKeyAccess(t *testing.T) {
+	restoreAPIKeys(t)
+	resetLimiter(t, 1, 5)
+	if err := reloadAPIKeys(writeAPIKeyFile(t, testAPIKeys), true); err != nil {
+		t.Fatal(err)
+	}
+
+	origMaxRows := maxRows
+	maxRows = 1
+	defer func() { maxRows = origMaxRows }()
+
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_keys",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "600000000000000000", "1000000100000000000000000000", 1763197210, 100, 1),
+		testRateLine("r3", "700000000000000000", "1000000200000000000000000000", 1763197220, 101, 0),
+	)
+
+	r := chi.NewRouter()
+	r.Use(apiKeyMiddleware)
+	registerAPIRoutes(r)
+	serve := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	events := func(rr *httptest.ResponseRecorder) int {
+		var page struct {
+			Events []json.RawMessage `json:"events"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to parse JSON response: %v", err)
+		}
+		return len(page.Events)
+	}
+
+	// Anonymous clients keep the default limits
+	rr := serve("/ri_test_keys/events.json", "")
+	if rr.Code != http.StatusOK || events(rr) != 1 {
+		t.Fatalf("expected 1 anonymous event, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("RateLimit-Limit") != "5" || !strings.HasPrefix(rr.Header().Get("Cache-Control"), "public") {
+		t.Errorf("unexpected anonymous headers %v", rr.Header())
+	}
+	if rr.Header().Get("Vary") != "X-API-Key" {
+		t.Errorf("expected Vary: X-API-Key, got %q", rr.Header().Get("Vary"))
+	}
+
+	// Partner keys raise the row and rate limits (in a bucket of their own)
+	rr = serve("/ri_test_keys/events.json", "partner-key-0123456789")
+	if rr.Code != http.StatusOK || events(rr) != 3 {
+		t.Fatalf("expected 3 partner events, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("RateLimit-Limit") != "20" || rr.Header().Get("RateLimit-Remaining") != "19" {
+		t.Errorf("unexpected partner rate limit headers %v", rr.Header())
+	}
+	if cc := rr.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private") {
+		t.Errorf("expected private Cache-Control for API key, got %q", cc)
+	}
+	if rr := serve("/ri_test_keys/events.json?api_key=partner-key-0123456789", ""); events(rr) != 3 {
+		t.Errorf("expected api_key query parameter to be accepted")
+	}
+
+	// Next links keep an api_key query parameter (and its tier)
+	rr = serve("/ri_test_keys/events.json?limit=2&api_key=partner-key-0123456789", "")
+	var page struct {
+		Next *string `json:"next"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if page.Next == nil || !strings.Contains(*page.Next, "api_key=partner-key-0123456789") {
+		t.Fatalf("expected next link with api_key, got %v", page.Next)
+	}
+	if rr := serve(*page.Next, ""); rr.Code != http.StatusOK || events(rr) != 1 {
+		t.Errorf("expected 1 partner event on the next page, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Restricted tiers are limited to their routes
+	if rr := serve("/ri_test_keys/events.json", "latest-key-0123456789"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for restricted route, got %d", rr.Code)
+	}
+	if rr := serve("/ri_test_keys/latest.json", "latest-key-0123456789"); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 for allowed route, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Unknown keys are rejected
+	rr = serve("/ri_test_keys/events.json", "unknown-key-0123456789")
+	var errResp ErrorResponse
+	if rr.Code != http.StatusUnauthorized || json.Unmarshal(rr.Body.Bytes(), &errResp) != nil || errResp.Error != "Invalid API key" {
+		t.Errorf("expected 401 Invalid API key, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strings"
+	"time"
 )
 
 // corsOriginsFlag implements flag.Value for parsing CORS origins as a JSON array
@@ -37,17 +40,131 @@ func (c *corsOriginsFlag) Set(value string) error {
 	return nil
 }
 
+// stalenessFlag implements flag.Value for parsing staleness thresholds as a
+// JSON object of database name prefixes and durations
+type stalenessFlag struct {
+	thresholds map[string]time.Duration
+}
+
+func (s *stalenessFlag) String() string {
+	values := make(map[string]string, len(s.thresholds))
+	for prefix, threshold := range s.thresholds {
+		values[prefix] = threshold.String()
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func (s *stalenessFlag) Set(value string) error {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return fmt.Errorf("invalid JSON object for staleness thresholds: %v", err)
+	}
+
+	thresholds := make(map[string]time.Duration, len(s.thresholds))
+	for prefix, threshold := range s.thresholds {
+		thresholds[prefix] = threshold
+	}
+	for prefix, value := range values {
+		if _, ok := catalogSQL[prefix]; !ok {
+			return fmt.Errorf("invalid database name prefix: %s", prefix)
+		}
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return fmt.Errorf("invalid staleness threshold of %s: %s", prefix, value)
+		}
+		thresholds[prefix] = threshold
+	}
+	s.thresholds = thresholds
+	return nil
+}
+
+// networksFlag implements flag.Value for parsing networks as a JSON array of
+// CIDR ranges or single addresses
+type networksFlag struct {
+	networks []*net.IPNet
+}
+
+func (n *networksFlag) String() string {
+	data, _ := json.Marshal(networkStrings(n.networks))
+	return string(data)
+}
+
+func (n *networksFlag) Set(value string) error {
+	var values []string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return fmt.Errorf("invalid JSON array for networks: %v", err)
+	}
+
+	networks, err := parseNetworks(values...)
+	if err != nil {
+		return err
+	}
+	n.networks = networks
+	return nil
+}
+
+// parseNetworks parses CIDR ranges or single addresses (as /32 or /128)
+func parseNetworks(values ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// mustParseNetworks parses default networks, panicking on invalid ones
+func mustParseNetworks(values ...string) []*net.IPNet {
+	networks, err := parseNetworks(values...)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// networkStrings returns the CIDR notation of networks
+func networkStrings(networks []*net.IPNet) []string {
+	values := make([]string, 0, len(networks))
+	for _, network := range networks {
+		values = append(values, network.String())
+	}
+	return values
+}
+
 // parseArgs parses command-line arguments and updates the global config variables
 func parseArgs() {
 	var corsOriginsValue corsOriginsFlag
+	var stalenessValue stalenessFlag
+	var proxiesValue networksFlag
 
 	// Use existing default CORS origins from config.go
 	corsOriginsValue.origins = allowedOrigins
+	stalenessValue.thresholds = maxStaleness
+	proxiesValue.networks = trustedProxies
 
 	// Define flags with both short and long forms, using defaults from config.go
 	helpPtr := flag.Bool("h", false, "Show help message")
 	flag.BoolVar(helpPtr, "help", false, "Show help message")
 
+	configPtr := flag.String("c", "", "Path to a YAML config file")
+	flag.StringVar(configPtr, "config", "", "Path to a YAML config file")
+
+	printConfigPtr := flag.Bool("print-config", false, "Print the effective configuration and exit")
+
 	maxRowsPtr := flag.Int("R", maxRows, "Maximum number of rows to return per query")
 	flag.IntVar(maxRowsPtr, "max-rows", maxRows, "Maximum number of rows to return per query")
 
@@ -57,6 +174,54 @@ func parseArgs() {
 	listenPortPtr := flag.String("p", listenPort, "HTTP server listen port")
 	flag.StringVar(listenPortPtr, "port", listenPort, "HTTP server listen port")
 
+	metricsAddrPtr := flag.String("M", metricsAddr, "Separate listen address for /metrics (e.g., 127.0.0.1:9101; not served if empty)")
+	flag.StringVar(metricsAddrPtr, "metrics-addr", metricsAddr, "Separate listen address for /metrics (e.g., 127.0.0.1:9101; not served if empty)")
+
+	logFormatPtr := flag.String("L", logFormat, "Log output format (text or json)")
+	flag.StringVar(logFormatPtr, "log-format", logFormat, "Log output format (text or json)")
+
+	readTimeoutPtr := flag.Duration("r", readTimeout, "Maximum duration for reading requests")
+	flag.DurationVar(readTimeoutPtr, "read-timeout", readTimeout, "Maximum duration for reading requests")
+
+	writeTimeoutPtr := flag.Duration("w", writeTimeout, "Maximum duration for writing responses (except streams)")
+	flag.DurationVar(writeTimeoutPtr, "write-timeout", writeTimeout, "Maximum duration for writing responses (except streams)")
+
+	idleTimeoutPtr := flag.Duration("i", idleTimeout, "Maximum duration of idle keep-alive connections")
+	flag.DurationVar(idleTimeoutPtr, "idle-timeout", idleTimeout, "Maximum duration of idle keep-alive connections")
+
+	shutdownTimeoutPtr := flag.Duration("s", shutdownTimeout, "Maximum duration to drain in-flight requests on shutdown")
+	flag.DurationVar(shutdownTimeoutPtr, "shutdown-timeout", shutdownTimeout, "Maximum duration to drain in-flight requests on shutdown")
+
+	queryTimeoutPtr := flag.Duration("q", queryTimeout, "Maximum duration of database queries")
+	flag.DurationVar(queryTimeoutPtr, "query-timeout", queryTimeout, "Maximum duration of database queries")
+
+	watchIntervalPtr := flag.Duration("W", watchInterval, "Interval of rescanning the database directory (0 disables)")
+	flag.DurationVar(watchIntervalPtr, "watch-interval", watchInterval, "Interval of rescanning the database directory (0 disables)")
+
+	flag.Var(&stalenessValue, "S", `Staleness thresholds per prefix as JSON object (e.g., {"rt_":"2h"})`)
+	flag.Var(&stalenessValue, "max-staleness", `Staleness thresholds per prefix as JSON object (e.g., {"rt_":"2h"})`)
+
+	queriesPathPtr := flag.String("Q", queriesPath, "Directory of query files (*.sql) to serve as endpoints")
+	flag.StringVar(queriesPathPtr, "queries-dir", queriesPath, "Directory of query files (*.sql) to serve as endpoints")
+
+	cacheSizePtr := flag.Int("C", cacheSize, "Maximum number of cached query results (0 disables caching)")
+	flag.IntVar(cacheSizePtr, "cache-size", cacheSize, "Maximum number of cached query results (0 disables caching)")
+
+	cacheTTLPtr := flag.Duration("T", cacheTTL, "Maximum age of cached query results")
+	flag.DurationVar(cacheTTLPtr, "cache-ttl", cacheTTL, "Maximum age of cached query results")
+
+	rateLimitPtr := flag.Float64("l", rateLimit, "Rate limit of API requests per client IP in tokens per second (0 disables)")
+	flag.Float64Var(rateLimitPtr, "rate-limit", rateLimit, "Rate limit of API requests per client IP in tokens per second (0 disables)")
+
+	rateBurstPtr := flag.Int("b", rateBurst, "Rate limit burst of API requests per client IP in tokens")
+	flag.IntVar(rateBurstPtr, "rate-burst", rateBurst, "Rate limit burst of API requests per client IP in tokens")
+
+	flag.Var(&proxiesValue, "t", `Trusted proxies as JSON array of networks (e.g., ["127.0.0.1/32"])`)
+	flag.Var(&proxiesValue, "trusted-proxies", `Trusted proxies as JSON array of networks (e.g., ["127.0.0.1/32"])`)
+
+	apiKeysPathPtr := flag.String("k", apiKeysPath, "Path to a YAML file of API keys and their tiers")
+	flag.StringVar(apiKeysPathPtr, "api-keys", apiKeysPath, "Path to a YAML file of API keys and their tiers")
+
 	flag.Var(&corsOriginsValue, "O", `CORS allowed origins as JSON array (e.g., ["https://example.com"])`)
 	flag.Var(&corsOriginsValue, "cors-origins", `CORS allowed origins as JSON array (e.g., ["https://example.com"])`)
 
@@ -69,21 +234,66 @@ func parseArgs() {
 		}
 		originsJSON, _ := json.Marshal(origins)
 
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s ingest --kind=ri|rt [options] <db>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s verify [options] <db>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s backfill [options] <db>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s migrate [options] <db>\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "XPower Banq API Server\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fmt.Fprintf(os.Stderr, "  -h, --help\n")
 		fmt.Fprintf(os.Stderr, "        Show this help message and exit\n")
+		fmt.Fprintf(os.Stderr, "  -c, --config string\n")
+		fmt.Fprintf(os.Stderr, "        Path to a YAML config file (keys are the long option names)\n")
+		fmt.Fprintf(os.Stderr, "  --print-config\n")
+		fmt.Fprintf(os.Stderr, "        Print the effective configuration as YAML and exit\n")
 		fmt.Fprintf(os.Stderr, "  -R, --max-rows int\n")
 		fmt.Fprintf(os.Stderr, "        Maximum number of rows to return per query (default: %d)\n", maxRows)
 		fmt.Fprintf(os.Stderr, "  -P, --db-path string\n")
 		fmt.Fprintf(os.Stderr, "        Path to the database directory (default: %s)\n", dbPath)
 		fmt.Fprintf(os.Stderr, "  -p, --port string\n")
 		fmt.Fprintf(os.Stderr, "        HTTP server listen port (default: %s)\n", listenPort)
+		fmt.Fprintf(os.Stderr, "  -M, --metrics-addr string\n")
+		fmt.Fprintf(os.Stderr, "        Separate listen address for /metrics, e.g. 127.0.0.1:9101 (default: not served)\n")
+		fmt.Fprintf(os.Stderr, "  -L, --log-format string\n")
+		fmt.Fprintf(os.Stderr, "        Log output format, text or json (default: %s)\n", logFormat)
+		fmt.Fprintf(os.Stderr, "  -r, --read-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration for reading requests (default: %s)\n", readTimeout)
+		fmt.Fprintf(os.Stderr, "  -w, --write-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration for writing responses, except streams (default: %s)\n", writeTimeout)
+		fmt.Fprintf(os.Stderr, "  -i, --idle-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration of idle keep-alive connections (default: %s)\n", idleTimeout)
+		fmt.Fprintf(os.Stderr, "  -s, --shutdown-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration to drain in-flight requests on shutdown (default: %s)\n", shutdownTimeout)
+		fmt.Fprintf(os.Stderr, "  -q, --query-timeout duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration of database queries (default: %s)\n", queryTimeout)
+		fmt.Fprintf(os.Stderr, "  -W, --watch-interval duration\n")
+		fmt.Fprintf(os.Stderr, "        Interval of rescanning the database directory, 0 disables (default: %s)\n", watchInterval)
+		fmt.Fprintf(os.Stderr, "  -S, --max-staleness string\n")
+		fmt.Fprintf(os.Stderr, "        Readiness staleness thresholds per prefix as JSON object, 0 disables\n")
+		fmt.Fprintf(os.Stderr, "        (default: %s)\n", (&stalenessFlag{maxStaleness}).String())
+		fmt.Fprintf(os.Stderr, "  -Q, --queries-dir string\n")
+		fmt.Fprintf(os.Stderr, "        Directory of query files (*.sql) to serve as endpoints (default: none)\n")
+		fmt.Fprintf(os.Stderr, "  -C, --cache-size int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum number of cached query results, 0 disables caching (default: %d)\n", cacheSize)
+		fmt.Fprintf(os.Stderr, "  -T, --cache-ttl duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum age of cached query results (default: %s)\n", cacheTTL)
+		fmt.Fprintf(os.Stderr, "  -l, --rate-limit float\n")
+		fmt.Fprintf(os.Stderr, "        Rate limit of API requests per client IP in tokens per second, 0 disables (default: %g)\n", rateLimit)
+		fmt.Fprintf(os.Stderr, "  -b, --rate-burst int\n")
+		fmt.Fprintf(os.Stderr, "        Rate limit burst of API requests per client IP in tokens (default: %d)\n", rateBurst)
+		fmt.Fprintf(os.Stderr, "  -t, --trusted-proxies string\n")
+		fmt.Fprintf(os.Stderr, "        Proxies trusted to set X-Real-IP and X-Forwarded-For as JSON array of networks\n")
+		fmt.Fprintf(os.Stderr, "        (default: %s)\n", (&networksFlag{trustedProxies}).String())
+		fmt.Fprintf(os.Stderr, "  -k, --api-keys string\n")
+		fmt.Fprintf(os.Stderr, "        Path to a YAML file of API keys and their tiers (default: none)\n")
 		fmt.Fprintf(os.Stderr, "  -O, --cors-origins string\n")
 		fmt.Fprintf(os.Stderr, "        CORS allowed origins as JSON array\n")
 		fmt.Fprintf(os.Stderr, "        (default: %s)\n", originsJSON)
 		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Options can also be set by %s* environment variables (e.g. %s)\n", envPrefix, envName("max-rows"))
+		fmt.Fprintf(os.Stderr, "or a config file, in order of precedence: options > environment > config file.\n")
+		fmt.Fprintf(os.Stderr, "\n")
 	}
 
 	// Parse command-line flags
@@ -95,9 +305,40 @@ func parseArgs() {
 		os.Exit(0)
 	}
 
+	// Apply environment variables and config file to options not given
+	if err := applySettings(flag.CommandLine, *configPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	// Update global config variables
 	maxRows = *maxRowsPtr
 	dbPath = *dbPathPtr
 	listenPort = *listenPortPtr
+	metricsAddr = *metricsAddrPtr
+	logFormat = *logFormatPtr
+	readTimeout = *readTimeoutPtr
+	writeTimeout = *writeTimeoutPtr
+	idleTimeout = *idleTimeoutPtr
+	shutdownTimeout = *shutdownTimeoutPtr
+	queryTimeout = *queryTimeoutPtr
+	watchInterval = *watchIntervalPtr
+	cacheSize = *cacheSizePtr
+	cacheTTL = *cacheTTLPtr
+	rateLimit = *rateLimitPtr
+	rateBurst = *rateBurstPtr
+	trustedProxies = proxiesValue.networks
+	apiKeysPath = *apiKeysPathPtr
 	allowedOrigins = corsOriginsValue.origins
+	maxStaleness = stalenessValue.thresholds
+	queriesPath = *queriesPathPtr
+
+	// Print effective configuration if requested
+	if *printConfigPtr {
+		if err := printConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 }
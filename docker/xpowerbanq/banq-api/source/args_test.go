@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCorsOriginsFlag_String(t *testing.T) {
@@ -440,3 +441,156 @@ func TestHelpFlag_Long(t *testing.T) {
 		t.Error("Help output missing default value for port")
 	}
 }
+
+func TestParseArgs_CacheFlags(t *testing.T) {
+	// Save original values
+	origCacheSize := cacheSize
+	origCacheTTL := cacheTTL
+	origArgs := os.Args
+
+	// Restore original values after test
+	defer func() {
+		cacheSize = origCacheSize
+		cacheTTL = origCacheTTL
+		os.Args = origArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+
+	// Simulate command-line arguments with short and long cache flags
+	os.Args = []string{"cmd", "-C", "0", "--cache-ttl", "30s"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if cacheSize != 0 {
+		t.Errorf("cacheSize = %v, want 0", cacheSize)
+	}
+	if cacheTTL != 30*time.Second {
+		t.Errorf("cacheTTL = %v, want 30s", cacheTTL)
+	}
+}
+
+func TestParseArgs_MetricsAddr(t *testing.T) {
+	// Save original values
+	origMetricsAddr := metricsAddr
+	origArgs := os.Args
+
+	// Restore original values after test
+	defer func() {
+		metricsAddr = origMetricsAddr
+		os.Args = origArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+
+	os.Args = []string{"cmd", "--metrics-addr", "127.0.0.1:9101"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if metricsAddr != "127.0.0.1:9101" {
+		t.Errorf("metricsAddr = %v, want 127.0.0.1:9101", metricsAddr)
+	}
+}
+
+func TestParseArgs_LogFormat(t *testing.T) {
+	// Save original values
+	origLogFormat := logFormat
+	origArgs := os.Args
+
+	// Restore original values after test
+	defer func() {
+		logFormat = origLogFormat
+		os.Args = origArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+
+	os.Args = []string{"cmd", "-L", "json"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if logFormat != "json" {
+		t.Errorf("logFormat = %v, want json", logFormat)
+	}
+}
+
+func TestParseArgs_Timeouts(t *testing.T) {
+	// Save original values
+	origReadTimeout := readTimeout
+	origWriteTimeout := writeTimeout
+	origIdleTimeout := idleTimeout
+	origShutdownTimeout := shutdownTimeout
+	origArgs := os.Args
+
+	// Restore original values after test
+	defer func() {
+		readTimeout = origReadTimeout
+		writeTimeout = origWriteTimeout
+		idleTimeout = origIdleTimeout
+		shutdownTimeout = origShutdownTimeout
+		os.Args = origArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	}()
+
+	os.Args = []string{"cmd", "-r", "5s", "--write-timeout", "30s", "-i", "1m", "--shutdown-timeout", "2s"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if readTimeout != 5*time.Second {
+		t.Errorf("readTimeout = %v, want 5s", readTimeout)
+	}
+	if writeTimeout != 30*time.Second {
+		t.Errorf("writeTimeout = %v, want 30s", writeTimeout)
+	}
+	if idleTimeout != time.Minute {
+		t.Errorf("idleTimeout = %v, want 1m", idleTimeout)
+	}
+	if shutdownTimeout != 2*time.Second {
+		t.Errorf("shutdownTimeout = %v, want 2s", shutdownTimeout)
+	}
+}
+
+func TestParseArgs_RateLimit(t *testing.T) {
+	restoreConfig(t)
+
+	os.Args = []string{"cmd", "-l", "2.5", "--rate-burst", "10", "-t", `["10.0.0.0/8", "192.0.2.1"]`}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if rateLimit != 2.5 || rateBurst != 10 {
+		t.Errorf("rateLimit, rateBurst = %v, %v, want 2.5, 10", rateLimit, rateBurst)
+	}
+	if got := networkStrings(trustedProxies); !reflect.DeepEqual(got, []string{"10.0.0.0/8", "192.0.2.1/32"}) {
+		t.Errorf("trustedProxies = %v, want [10.0.0.0/8 192.0.2.1/32]", got)
+	}
+}
+
+func TestNetworksFlag_Set(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		wantErr  bool
+	}{
+		{"CIDR ranges", `["127.0.0.0/8","::1/128"]`, []string{"127.0.0.0/8", "::1/128"}, false},
+		{"single addresses", `["192.0.2.1","2001:db8::1"]`, []string{"192.0.2.1/32", "2001:db8::1/128"}, false},
+		{"empty", `[]`, []string{}, false},
+		{"invalid JSON", `127.0.0.1`, nil, true},
+		{"invalid network", `["localhost"]`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n networksFlag
+			err := n.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(networkStrings(n.networks), tt.expected) {
+				t.Errorf("networks = %v, want %v", networkStrings(n.networks), tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backfillSchemaSQL creates the side tables of the chain head a backfill
+// counts its chunks back from (per chunk size, resolved by the first run)
+// and of the completed block ranges
+const backfillSchemaSQL = `
+CREATE TABLE IF NOT EXISTS backfill_heads (
+  blocks INTEGER NOT NULL PRIMARY KEY,
+  head INTEGER NOT NULL,
+  resolved_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+CREATE TABLE IF NOT EXISTS backfill_ranges (
+  from_block INTEGER NOT NULL,
+  to_block INTEGER NOT NULL,
+  logs INTEGER NOT NULL,
+  completed_at TEXT NOT NULL DEFAULT (datetime('now')),
+  PRIMARY KEY (from_block, to_block)
+);
+`
+
+// backfillHeadDrift is the number of blocks the chain head may advance
+// between resolving it and banq resolving it (~1 hour of 2s blocks)
+var backfillHeadDrift int64 = 1800
+
+// backfillOptions configure a backfill of chunks 0 to chunks-1 of blocks
+// each, counted back from the persisted head
+type backfillOptions struct {
+	command    string        // banq executable (looked up on PATH)
+	blocks     int           // blocks per chunk
+	chunks     int           // number of chunks
+	parallel   int           // concurrent banq commands
+	retries    int           // retries of a failed chunk
+	retryDelay time.Duration // before the first retry (doubled per retry)
+	ingest     ingestOptions
+
+	// head returns the live chain head
+	head func(ctx context.Context) (int64, error)
+}
+
+// backfillStats counts the chunks of a backfill
+type backfillStats struct {
+	completed int // chunks ingested and checkpointed
+	resumed   int // chunks checkpointed by previous runs
+	failed    int // chunks that failed after all retries
+	logs      int // logs inserted or replaced
+}
+
+// blockRange is the absolute (inclusive) block range of a chunk
+type blockRange struct {
+	from, to int64
+}
+
+// chunkRanges returns the block ranges of chunks 0 to chunks-1 counted back
+// from a head (ending at block 0)
+func chunkRanges(head int64, blocks, chunks int) []blockRange {
+	var ranges []blockRange
+	for chunk := 0; chunk < chunks; chunk++ {
+		to := head - int64(chunk)*int64(blocks)
+		if to < 0 {
+			break
+		}
+		ranges = append(ranges, blockRange{from: max(to-int64(blocks)+1, 0), to: to})
+	}
+	return ranges
+}
+
+// watchWindow returns the smallest banq --watch=SIZE@INDEX window (counted
+// back from the live head) that covers the blocks from..to even if the head
+// advances by up to drift blocks before banq resolves it
+func watchWindow(live, from, to, drift int64) (size, index int64) {
+	depth := max(live-to, 0)
+	for size = to - from + 1 + drift; ; size++ {
+		index = depth / size
+		if (index+1)*size-depth >= to-from+1+drift {
+			return size, index
+		}
+	}
+}
+
+// backfillArgs returns the banq arguments scanning a window of a database,
+// as run by the former banq-ri.sync.sh and banq-rt.sync.sh scripts
+func backfillArgs(name DatabaseName, size, index int64) []string {
+	watch := fmt.Sprintf("--watch=%d@%d", size, index)
+	if name.Kind == "rt" {
+		return []string{"rt", name.Source, name.Target, "-o", name.Oracle, "-YP", watch}
+	}
+	return []string{"ri", name.Token, "--mode=" + name.Mode, "-p", name.Pool, "-YP", watch}
+}
+
+// chainHead returns the latest block number of a JSON-RPC provider
+func chainHead(ctx context.Context, providerURL string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, providerURL, strings.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve chain head: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve chain head: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to resolve chain head: %s", resp.Status)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to resolve chain head: %v", err)
+	}
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to resolve chain head: %s", result.Error.Message)
+	}
+	head, err := strconv.ParseInt(strings.TrimPrefix(result.Result, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve chain head: invalid block number %q", result.Result)
+	}
+	return head, nil
+}
+
+// backfillHead returns the persisted head of a chunk size, resolving and
+// persisting it on the first run, so that re-runs cover the same ranges
+func backfillHead(ctx context.Context, db *sql.DB, opts backfillOptions) (int64, error) {
+	var head int64
+	err := db.QueryRowContext(ctx, "SELECT head FROM backfill_heads WHERE blocks = ?", opts.blocks).Scan(&head)
+	if err == nil {
+		return head, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to read head: %v", err)
+	}
+
+	if head, err = opts.head(ctx); err != nil {
+		return 0, err
+	}
+	if _, err := db.ExecContext(ctx,
+		"INSERT INTO backfill_heads(blocks, head) VALUES(?, ?)", opts.blocks, head,
+	); err != nil {
+		return 0, fmt.Errorf("failed to persist head: %v", err)
+	}
+	slog.Info("Backfill head resolved", "blocks", opts.blocks, "head", head)
+	return head, nil
+}
+
+// completedRanges returns the checkpointed block ranges
+func completedRanges(ctx context.Context, db *sql.DB) (map[blockRange]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT from_block, to_block FROM backfill_ranges")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %v", err)
+	}
+	defer rows.Close()
+
+	completed := make(map[blockRange]bool)
+	for rows.Next() {
+		var r blockRange
+		if err := rows.Scan(&r.from, &r.to); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoints: %v", err)
+		}
+		completed[r] = true
+	}
+	return completed, rows.Err()
+}
+
+// fetchChunk runs banq for the window covering a block range (relative to
+// the live head of each attempt) and returns its output, retrying failed
+// runs with exponential backoff
+func fetchChunk(ctx context.Context, name DatabaseName, r blockRange, opts backfillOptions) ([]byte, error) {
+	delay := opts.retryDelay
+	for attempt := 0; ; attempt++ {
+		var stderr bytes.Buffer
+		live, err := opts.head(ctx)
+		if err == nil {
+			size, index := watchWindow(live, r.from, r.to, backfillHeadDrift)
+			cmd := exec.CommandContext(ctx, opts.command, backfillArgs(name, size, index)...)
+			cmd.Stderr = &stderr
+			var output []byte
+			if output, err = cmd.Output(); err == nil {
+				return output, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		slog.Warn("Chunk failed", "from", r.from, "to", r.to, "attempt", attempt+1, "err", err,
+			"stderr", lastLine(stderr.String()))
+		if attempt >= opts.retries {
+			return nil, fmt.Errorf("blocks %d to %d failed after %d attempts: %v", r.from, r.to, attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of a command's output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// backfill runs banq for every chunk whose block range has not been
+// checkpointed yet (at most opts.parallel at a time), ingests the logs of the
+// range and checkpoints it; failed chunks do not stop the others and are
+// retried by the next run
+func backfill(ctx context.Context, db *sql.DB, name DatabaseName, opts backfillOptions) (backfillStats, error) {
+	var stats backfillStats
+	if _, err := db.ExecContext(ctx, backfillSchemaSQL); err != nil {
+		return stats, fmt.Errorf("failed to create checkpoint tables: %v", err)
+	}
+	head, err := backfillHead(ctx, db, opts)
+	if err != nil {
+		return stats, err
+	}
+	completed, err := completedRanges(ctx, db)
+	if err != nil {
+		return stats, err
+	}
+
+	// Stop all chunks on write errors (but not on failed chunks)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	ranges := chunkRanges(head, opts.blocks, opts.chunks)
+	chunks := make(chan blockRange)
+	var wg sync.WaitGroup
+	var mux sync.Mutex // serializes writes and stats
+	for w := 0; w < opts.parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range chunks {
+				output, err := fetchChunk(ctx, name, r, opts)
+				if err != nil {
+					mux.Lock()
+					if ctx.Err() == nil {
+						stats.failed++
+					}
+					mux.Unlock()
+					continue
+				}
+
+				// Ingest completely (even if interrupted meanwhile), so
+				// that a checkpoint is never written for a partial chunk
+				mux.Lock()
+				logs, err := backfillChunk(context.WithoutCancel(ctx), db, name, r, output, opts)
+				if err != nil {
+					cancel(err)
+				} else {
+					stats.completed++
+					stats.logs += logs
+				}
+				mux.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, r := range ranges {
+		if completed[r] {
+			stats.resumed++
+			continue
+		}
+		select {
+		case chunks <- r:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	switch {
+	case context.Cause(ctx) != nil:
+		return stats, context.Cause(ctx)
+	case stats.failed > 0:
+		return stats, fmt.Errorf("%d of %d chunks failed", stats.failed, len(ranges))
+	default:
+		return stats, nil
+	}
+}
+
+// backfillChunk ingests the logs of a block range (ignoring those of the
+// surrounding blocks banq scanned too) and checkpoints the range
+func backfillChunk(ctx context.Context, db *sql.DB, name DatabaseName, r blockRange, output []byte, opts backfillOptions) (int, error) {
+	ingestOpts := opts.ingest
+	ingestOpts.fromBlock, ingestOpts.toBlock = r.from, r.to
+	stats, err := ingest(ctx, db, ingestKinds[name.Kind], bytes.NewReader(output), ingestOpts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to ingest blocks %d to %d: %v", r.from, r.to, err)
+	}
+	if _, err := db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO backfill_ranges(from_block, to_block, logs) VALUES(?, ?, ?)",
+		r.from, r.to, stats.inserted,
+	); err != nil {
+		return 0, fmt.Errorf("failed to checkpoint blocks %d to %d: %v", r.from, r.to, err)
+	}
+	slog.Info("Chunk completed", "from", r.from, "to", r.to, "logs", stats.inserted, "skipped", stats.skipped)
+	return stats.inserted, nil
+}
+
+// runBackfill runs the backfill subcommand, returning its exit status
+func runBackfill(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+
+	blocksPtr := flags.Int("b", 86400, "Blocks per chunk")
+	flags.IntVar(blocksPtr, "blocks", 86400, "Blocks per chunk")
+
+	chunksPtr := flags.Int("n", 365, "Number of chunks")
+	flags.IntVar(chunksPtr, "chunks", 365, "Number of chunks")
+
+	parallelPtr := flags.Int("P", 4, "Maximum number of concurrent banq commands")
+	flags.IntVar(parallelPtr, "parallel", 4, "Maximum number of concurrent banq commands")
+
+	retriesPtr := flags.Int("r", 3, "Retries of a failed chunk")
+	flags.IntVar(retriesPtr, "retries", 3, "Retries of a failed chunk")
+
+	retryDelayPtr := flags.Duration("R", 5*time.Second, "Delay before the first retry (doubled per retry)")
+	flags.DurationVar(retryDelayPtr, "retry-delay", 5*time.Second, "Delay before the first retry (doubled per retry)")
+
+	providerURLPtr := flags.String("u", os.Getenv("PROVIDER_URL"), "JSON-RPC provider resolving the chain head")
+	flags.StringVar(providerURLPtr, "provider-url", os.Getenv("PROVIDER_URL"), "JSON-RPC provider resolving the chain head")
+
+	commandPtr := flags.String("c", "banq", "banq executable")
+	flags.StringVar(commandPtr, "command", "banq", "banq executable")
+
+	logFormatPtr := flags.String("L", logFormat, "Log output format (text or json)")
+	flags.StringVar(logFormatPtr, "log-format", logFormat, "Log output format (text or json)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s backfill [options] <db>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Backfill a database chunk by chunk with banq ri or banq rt (resuming\n")
+		fmt.Fprintf(os.Stderr, "after the chunks completed by previous runs)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "  -b, --blocks int\n")
+		fmt.Fprintf(os.Stderr, "        Blocks per chunk (default: 86400)\n")
+		fmt.Fprintf(os.Stderr, "  -n, --chunks int\n")
+		fmt.Fprintf(os.Stderr, "        Number of chunks (default: 365)\n")
+		fmt.Fprintf(os.Stderr, "  -P, --parallel int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum number of concurrent banq commands (default: 4)\n")
+		fmt.Fprintf(os.Stderr, "  -r, --retries int\n")
+		fmt.Fprintf(os.Stderr, "        Retries of a failed chunk (default: 3)\n")
+		fmt.Fprintf(os.Stderr, "  -R, --retry-delay duration\n")
+		fmt.Fprintf(os.Stderr, "        Delay before the first retry, doubled per retry (default: 5s)\n")
+		fmt.Fprintf(os.Stderr, "  -u, --provider-url string\n")
+		fmt.Fprintf(os.Stderr, "        JSON-RPC provider resolving the chain head (default: $PROVIDER_URL)\n")
+		fmt.Fprintf(os.Stderr, "  -c, --command string\n")
+		fmt.Fprintf(os.Stderr, "        banq executable (default: banq)\n")
+		fmt.Fprintf(os.Stderr, "  -L, --log-format string\n")
+		fmt.Fprintf(os.Stderr, "        Log output format, text or json (default: %s)\n", logFormat)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *blocksPtr < 1 || *chunksPtr < 1 || *parallelPtr < 1 || *retriesPtr < 0 || *retryDelayPtr < 0 {
+		fmt.Fprintln(os.Stderr, "blocks, chunks and parallel must be positive, retries and delay not negative")
+		return 2
+	}
+	if *providerURLPtr == "" {
+		fmt.Fprintln(os.Stderr, "provider URL required (--provider-url or PROVIDER_URL)")
+		return 2
+	}
+	path := flags.Arg(0)
+	name, err := parseDatabaseName(strings.TrimSuffix(filepath.Base(path), ".db"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := setupLogger(*logFormatPtr, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, err := openIngestDatabase(path, ingestKinds[name.Kind])
+	if err != nil {
+		slog.Error("Backfill failed", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	// Stop on SIGTERM or SIGINT after ingesting the chunks fetched so far
+	// (running banq commands are killed and resumed by the next run)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	slog.Info("Backfill starting", "db", path, "blocks", *blocksPtr, "chunks", *chunksPtr)
+	stats, err := backfill(ctx, db, name, backfillOptions{
+		command:    *commandPtr,
+		blocks:     *blocksPtr,
+		chunks:     *chunksPtr,
+		parallel:   *parallelPtr,
+		retries:    *retriesPtr,
+		retryDelay: *retryDelayPtr,
+		head: func(ctx context.Context) (int64, error) {
+			return chainHead(ctx, *providerURLPtr)
+		},
+		ingest: ingestOptions{batchSize: 256, batchInterval: time.Second},
+	})
+	attrs := []any{"db", path, "completed", stats.completed, "resumed", stats.resumed,
+		"failed", stats.failed, "logs", stats.logs}
+	if err != nil {
+		slog.Error("Backfill failed", append(attrs, "err", err)...)
+		return 1
+	}
+	slog.Info("Backfill finished", attrs...)
+	return 0
+}
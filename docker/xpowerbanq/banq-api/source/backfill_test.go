@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeBanq emits one rate log per block of its --watch=SIZE@INDEX window
+// (counted back from $BANQ_HEAD, advanced by $BANQ_DRIFT) and records its
+// arguments; windows with block $BANQ_FAIL always fail and windows with
+// block $BANQ_FLAKY fail on their first run
+const fakeBanq = `#!/bin/sh
+for arg; do
+  case $arg in --watch=*) window=${arg#--watch=} ;; esac
+done
+size=${window%@*}
+to=$((BANQ_HEAD + ${BANQ_DRIFT:-0} - ${window#*@} * size))
+from=$((to - size + 1))
+echo "$*" >> "$BANQ_CALLS"
+echo "scanning blocks $from to $to" >&2
+if [ -n "$BANQ_FAIL" ] && [ "$from" -le "$BANQ_FAIL" ] && [ "$BANQ_FAIL" -le "$to" ]; then
+  echo "rpc error" >&2
+  exit 1
+fi
+if [ -n "$BANQ_FLAKY" ] && [ "$from" -le "$BANQ_FLAKY" ] && [ "$BANQ_FLAKY" -le "$to" ] &&
+  [ ! -e "$BANQ_CALLS.flaky" ]; then
+  touch "$BANQ_CALLS.flaky"
+  exit 3
+fi
+block=$((from > 1 ? from : 1))
+while [ "$block" -le "$to" ]; do
+  printf '{"id":"r%s","util_wad":"5n","index_ray":"1n","stamp":"%sn","log":{"blockHash":"0x%x","blockNumber":%s,"index":0,"removed":false}}\n' \
+    "$block" "$((1763197200 + block))" "$block" "$block"
+  block=$((block + 1))
+done
+`
+
+// serveHead serves the JSON-RPC block number $BANQ_HEAD and returns its URL
+func serveHead(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head, _ := strconv.ParseInt(os.Getenv("BANQ_HEAD"), 10, 64)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":"0x%x"}`, head)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// blockSpan returns the number of logs and the first and last block of them
+func blockSpan(t *testing.T, path string) (count, first, last int64) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRow(
+		"SELECT COUNT(*), MIN(log_block_number), MAX(log_block_number) FROM raw_logs",
+	).Scan(&count, &first, &last); err != nil {
+		t.Fatalf("failed to read blocks: %v", err)
+	}
+	return count, first, last
+}
+
+// installFakeBanq puts fakeBanq on PATH and returns the file of its calls
+func installFakeBanq(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "banq"), []byte(fakeBanq), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	calls := filepath.Join(dir, "calls")
+	t.Setenv("BANQ_CALLS", calls)
+	return calls
+}
+
+// readCalls returns the recorded calls of fakeBanq and clears them
+func readCalls(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	return strings.Fields(strings.ReplaceAll(string(data), " ", "_"))
+}
+
+func TestBackfillArgs(t *testing.T) {
+	ri, _ := parseDatabaseName("ri_apow_borrow_0")
+	if args := backfillArgs(ri, 86400, 7); !reflect.DeepEqual(args, []string{
+		"ri", "APOW", "--mode=borrow", "-p", "P000", "-YP", "--watch=86400@7",
+	}) {
+		t.Errorf("unexpected ri arguments %v", args)
+	}
+	rt, _ := parseDatabaseName("rt_apow_xpow_1")
+	if args := backfillArgs(rt, 100, 0); !reflect.DeepEqual(args, []string{
+		"rt", "APOW", "XPOW", "-o", "T001", "-YP", "--watch=100@0",
+	}) {
+		t.Errorf("unexpected rt arguments %v", args)
+	}
+}
+
+func TestChunkRanges(t *testing.T) {
+	if got := chunkRanges(100, 10, 3); !reflect.DeepEqual(got, []blockRange{{91, 100}, {81, 90}, {71, 80}}) {
+		t.Errorf("unexpected ranges %v", got)
+	}
+	if got := chunkRanges(15, 10, 3); !reflect.DeepEqual(got, []blockRange{{6, 15}, {0, 5}}) {
+		t.Errorf("expected ranges to end at block 0, got %v", got)
+	}
+}
+
+func TestWatchWindow(t *testing.T) {
+	if size, index := watchWindow(100, 71, 80, 0); size != 10 || index != 2 {
+		t.Errorf("expected exact window 10@2, got %d@%d", size, index)
+	}
+	for live := int64(0); live <= 40; live++ {
+		for from := int64(0); from <= 30; from++ {
+			for to := from; to <= from+10; to++ {
+				for drift := int64(0); drift <= 3; drift++ {
+					size, index := watchWindow(live, from, to, drift)
+					for head := live; head <= live+drift; head++ {
+						if last, first := head-index*size, head-(index+1)*size+1; first > from || last < min(to, head) {
+							t.Fatalf("window %d@%d of head %d (resolved %d, drift %d) misses blocks %d to %d",
+								size, index, head, live, drift, from, to)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestChainHead(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantHead int64
+		wantErr  string
+	}{
+		{"block number", `{"jsonrpc":"2.0","id":1,"result":"0x4b7c2a1"}`, 79151777, ""},
+		{"rpc error", `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"rate limited"}}`, 0, "rate limited"},
+		{"invalid result", `{"jsonrpc":"2.0","id":1,"result":"latest"}`, 0, "invalid block number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if !strings.Contains(string(body), `"eth_blockNumber"`) {
+					t.Errorf("unexpected request %s", body)
+				}
+				fmt.Fprint(w, tt.response)
+			}))
+			defer srv.Close()
+
+			head, err := chainHead(context.Background(), srv.URL)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || head != tt.wantHead {
+				t.Errorf("expected head %d, got %d (%v)", tt.wantHead, head, err)
+			}
+		})
+	}
+}
+
+func TestRunBackfill(t *testing.T) {
+	origLogger := slog.Default()
+	origDrift := backfillHeadDrift
+	t.Cleanup(func() {
+		slog.SetDefault(origLogger)
+		backfillHeadDrift = origDrift
+	})
+	backfillHeadDrift = 0
+
+	calls := installFakeBanq(t)
+	path := filepath.Join(t.TempDir(), "ri_apow_supply_0.db")
+	args := []string{"--blocks=10", "--chunks=4", "--parallel=2", "--retries=1", "--retry-delay=1ms",
+		"--provider-url=" + serveHead(t), path}
+
+	// Failed chunks do not stop the others, and flaky ones are retried
+	t.Setenv("BANQ_HEAD", "100")
+	t.Setenv("BANQ_FAIL", "75")
+	t.Setenv("BANQ_FLAKY", "85")
+	if code := runBackfill(args); code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	slog.SetDefault(origLogger)
+	got := readCalls(t, calls)
+	if len(got) != 6 { // blocks 91-100 and 61-70 once, 81-90 and 71-80 twice
+		t.Errorf("expected 6 calls, got %v", got)
+	}
+	if !slices.Contains(got, "ri_APOW_--mode=supply_-p_P000_-YP_--watch=10@0") {
+		t.Errorf("expected call of chunk 0, got %v", got)
+	}
+	if count := countLogs(t, path); count != 30 {
+		t.Errorf("expected 30 logs, got %d", count)
+	}
+
+	// Re-runs resume with the block ranges that are not checkpointed, even
+	// after the head moved on (ignoring the logs of the surrounding blocks)
+	t.Setenv("BANQ_HEAD", "107")
+	t.Setenv("BANQ_FAIL", "")
+	if code := runBackfill(args); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	slog.SetDefault(origLogger)
+	if got := readCalls(t, calls); !reflect.DeepEqual(got, []string{"ri_APOW_--mode=supply_-p_P000_-YP_--watch=13@2"}) {
+		t.Errorf("expected only blocks 71-80 to run, got %v", got)
+	}
+	if count, first, last := blockSpan(t, path); count != 40 || first != 61 || last != 100 {
+		t.Errorf("expected 40 logs of blocks 61 to 100, got %d of blocks %d to %d", count, first, last)
+	}
+
+	// Other chunk sizes resolve their own head, and windows tolerate the
+	// head advancing before banq resolves it
+	backfillHeadDrift = 5
+	t.Setenv("BANQ_DRIFT", "3")
+	if code := runBackfill([]string{"--blocks=20", "--chunks=1", "--provider-url=" + serveHead(t), path}); code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	slog.SetDefault(origLogger)
+	if got := readCalls(t, calls); !reflect.DeepEqual(got, []string{"ri_APOW_--mode=supply_-p_P000_-YP_--watch=25@0"}) {
+		t.Errorf("expected 1 call, got %v", got)
+	}
+	if count, first, last := blockSpan(t, path); count != 47 || first != 61 || last != 107 {
+		t.Errorf("expected 47 logs of blocks 61 to 107, got %d of blocks %d to %d", count, first, last)
+	}
+}
+
+func TestRunBackfillUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ri_apow_supply_0.db")
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"missing database", []string{}, 2},
+		{"invalid name", []string{filepath.Join(t.TempDir(), "apow.db")}, 2},
+		{"invalid chunks", []string{"--chunks=0", path}, 2},
+		{"invalid retries", []string{"-r", "-1", path}, 2},
+		{"missing provider URL", []string{"--provider-url=", path}, 2},
+		{"invalid flag", []string{"--unknown", path}, 2},
+		{"help", []string{"--help"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runBackfill(tt.args); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no database to be created, got %v", err)
+	}
+}
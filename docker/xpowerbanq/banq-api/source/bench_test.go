@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// legacyQuoteSchemaSQL mirrors the schema created by the former
+// banq-rtw2db.sh script (JSON views and expression indexes)
+const legacyQuoteSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS raw_logs (
+		id TEXT NOT NULL PRIMARY KEY,
+		json TEXT NOT NULL
+	);
+	CREATE VIEW IF NOT EXISTS rtw_view AS
+		SELECT
+			json_extract(json,'$.id') AS id,
+			(REPLACE(json_extract(json,'$.quote_bid'),'n','')+0.0)/1e18 AS quote_bid_e18,
+			json_extract(json,'$.quote_bid') AS quote_bid,
+			(REPLACE(json_extract(json,'$.quote_ask'),'n','')+0.0)/1e18 AS quote_ask_e18,
+			json_extract(json,'$.quote_ask') AS quote_ask,
+			datetime(CAST(REPLACE(json_extract(json,'$.quote_time'),'n','') AS INTEGER),'unixepoch') AS quote_time_iso,
+			json_extract(json,'$.quote_time') AS quote_time,
+			json_extract(json,'$.log.blockHash') AS log_block_hash,
+			CAST(json_extract(json,'$.log.blockNumber') AS INTEGER) AS log_block_number,
+			CAST(json_extract(json,'$.log.index') AS INTEGER) AS log_index,
+			CAST(json_extract(json,'$.log.removed') AS INTEGER) AS log_removed,
+			json_extract(json,'$.log.transactionHash') AS log_tx_hash,
+			json
+		FROM raw_logs;
+	CREATE INDEX IF NOT EXISTS idx_block_number
+		ON raw_logs (CAST(json_extract(json,'$.log.blockNumber') AS INTEGER));
+	CREATE INDEX IF NOT EXISTS idx_quote_time
+		ON raw_logs (CAST(REPLACE(json_extract(json,'$.quote_time'),'n','') AS INTEGER));
+`
+
+// Endpoint queries on the views of the former ingest scripts (which filter
+// and group on ISO 8601 strings computed from the JSON of every log)
+const (
+	legacyDailyAverageSQL = `
+		SELECT avg(util_e18) AS avg_util, date(stamp_iso) AS day, count(*) AS n
+		FROM riw_view
+		WHERE stamp_iso > ?1 AND stamp_iso <= ?2 || ' 23:59:59'
+			AND (?4 OR log_removed IS NOT 1)
+		GROUP BY day
+		ORDER BY day
+		LIMIT ?3`
+
+	legacyDailyOHLCSQL = `
+		WITH ranked_quotes AS (
+			SELECT
+				(quote_bid_e18+quote_ask_e18)/2 AS mid,
+				date(quote_time_iso) AS day,
+				ROW_NUMBER() OVER (PARTITION BY date(quote_time_iso) ORDER BY quote_time_iso ASC) AS rn_beg,
+				ROW_NUMBER() OVER (PARTITION BY date(quote_time_iso) ORDER BY quote_time_iso DESC) AS rn_end
+			FROM rtw_view
+			WHERE quote_time_iso > ?1 AND quote_time_iso <= ?2 || ' 23:59:59'
+				AND (?4 OR log_removed IS NOT 1)
+		)
+		SELECT
+			MAX(CASE WHEN rn_beg = 1 THEN mid END) AS open,
+			MAX(mid) AS high,
+			MIN(mid) AS low,
+			MAX(CASE WHEN rn_end = 1 THEN mid END) AS close,
+			day,
+			COUNT(*) AS n
+		FROM ranked_quotes
+		GROUP BY day
+		ORDER BY day
+		LIMIT ?3`
+
+	legacyDailyRateSQL = `
+		WITH ranked_rates AS (
+			SELECT
+				REPLACE(index_ray, 'n', '') AS index_ray,
+				CAST(REPLACE(stamp, 'n', '') AS INTEGER) AS stamp,
+				date(stamp_iso) AS day,
+				ROW_NUMBER() OVER (PARTITION BY date(stamp_iso) ORDER BY stamp_iso ASC, log_block_number ASC, log_index ASC) AS rn_beg,
+				ROW_NUMBER() OVER (PARTITION BY date(stamp_iso) ORDER BY stamp_iso DESC, log_block_number DESC, log_index DESC) AS rn_end
+			FROM riw_view
+			WHERE stamp_iso > ?1 AND stamp_iso <= ?2 || ' 23:59:59'
+				AND (?4 OR log_removed IS NOT 1)
+		)
+		SELECT
+			MAX(CASE WHEN rn_beg = 1 THEN index_ray END) AS first_index,
+			MAX(CASE WHEN rn_end = 1 THEN index_ray END) AS last_index,
+			MAX(CASE WHEN rn_beg = 1 THEN stamp END) AS first_stamp,
+			MAX(CASE WHEN rn_end = 1 THEN stamp END) AS last_stamp,
+			day,
+			COUNT(*) AS n
+		FROM ranked_rates
+		GROUP BY day
+		ORDER BY day
+		LIMIT ?3`
+
+	legacyIntervalOHLCSQL = `
+		WITH bucketed_quotes AS (
+			SELECT
+				(quote_bid_e18+quote_ask_e18)/2 AS mid,
+				quote_time_iso,
+				CASE WHEN ?3 = 0
+					THEN datetime(quote_time_iso, 'start of month')
+					ELSE datetime(
+						(CAST(strftime('%s', quote_time_iso) AS INTEGER) - (CASE WHEN ?3 % 604800 = 0 THEN 345600 ELSE 0 END)) / ?3 * ?3
+						+ (CASE WHEN ?3 % 604800 = 0 THEN 345600 ELSE 0 END), 'unixepoch')
+				END AS time
+			FROM rtw_view
+			WHERE quote_time_iso > ?1 AND quote_time_iso <= ?2 || ' 23:59:59'
+				AND (?5 OR log_removed IS NOT 1)
+		),
+		ranked_quotes AS (
+			SELECT
+				mid,
+				time,
+				ROW_NUMBER() OVER (PARTITION BY time ORDER BY quote_time_iso ASC) AS rn_beg,
+				ROW_NUMBER() OVER (PARTITION BY time ORDER BY quote_time_iso DESC) AS rn_end
+			FROM bucketed_quotes
+		)
+		SELECT
+			MAX(CASE WHEN rn_beg = 1 THEN mid END) AS open,
+			MAX(mid) AS high,
+			MIN(mid) AS low,
+			MAX(CASE WHEN rn_end = 1 THEN mid END) AS close,
+			time,
+			COUNT(*) AS n
+		FROM ranked_quotes
+		GROUP BY time
+		ORDER BY time
+		LIMIT ?4`
+
+	legacyLatestRateSQL = `
+		SELECT util_e18, index_e27, stamp_iso
+		FROM riw_view
+		WHERE ?2 OR log_removed IS NOT 1
+		ORDER BY stamp_iso DESC, log_block_number DESC, log_index DESC
+		LIMIT 1`
+
+	legacyLatestQuoteSQL = `
+		SELECT
+			quote_bid_e18, quote_ask_e18,
+			(quote_bid_e18+quote_ask_e18)/2 AS mid,
+			quote_time_iso
+		FROM rtw_view
+		WHERE ?2 OR log_removed IS NOT 1
+		ORDER BY quote_time_iso DESC, log_block_number DESC, log_index DESC
+		LIMIT 1`
+)
+
+// syntheticStart is the time of the first synthetic log (2025-01-01)
+var syntheticStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// syntheticDatabase creates a database of a kind with a rate every 10
+// minutes (ri) or a quote every 5 minutes (rt) for the given days, where
+// every 1000th log is removed; with the schema of the former ingest scripts
+// if legacy, else with typed columns
+func syntheticDatabase(tb testing.TB, kind string, days int, legacy bool) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), kind+"_synthetic.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	switch {
+	case legacy && kind == "rt":
+		_, err = db.Exec(legacyQuoteSchemaSQL)
+	case legacy:
+		_, err = db.Exec(legacyRateSchemaSQL)
+	default:
+		_, err = migrateDatabase(db, ingestKinds[kind])
+	}
+	if err != nil {
+		tb.Fatalf("failed to create schema: %v", err)
+	}
+
+	step := int64(600)
+	if kind == "rt" {
+		step = 300
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO raw_logs(id, json) VALUES(?, json_set(?, '$.log.removed', json(?)))")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i, stamp := 0, syntheticStart; stamp < syntheticStart+int64(days)*86400; i, stamp = i+1, stamp+step {
+		id := fmt.Sprintf("%s%d", kind, i)
+		var line string
+		if kind == "rt" {
+			bid := 1000000000000000000 + int64(i%997)*1000000000000
+			line = testQuoteLine(id, strconv.FormatInt(bid, 10), strconv.FormatInt(bid+2000000000000000, 10), stamp, 1000+i, 0)
+		} else {
+			util := 500000000000000000 + int64(i%991)*100000000000000
+			line = testRateLine(id, strconv.FormatInt(util, 10), fmt.Sprintf("1%027d", i), stamp, 1000+i, 0)
+		}
+		removed := "false"
+		if i%1000 == 999 {
+			removed = "true"
+		}
+		if _, err := stmt.Exec(id, line, removed); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// queryComparison is an endpoint query on the views of the former ingest
+// scripts and on the typed columns, with its arguments
+type queryComparison struct {
+	name   string
+	kind   string
+	legacy string
+	typed  string
+	args   []any
+}
+
+// queryComparisons returns the endpoint queries from day lhs to day rhs
+func queryComparisons(lhs, rhs string) []queryComparison {
+	return []queryComparison{
+		{"daily_average", "ri", legacyDailyAverageSQL, dailyAverageSQL, []any{lhs, rhs, 366, false}},
+		{"daily_rate", "ri", legacyDailyRateSQL, dailyRateSQL, []any{lhs, rhs, 366, false}},
+		{"daily_ohlc", "rt", legacyDailyOHLCSQL, dailyOHLCSQL, []any{lhs, rhs, 366, false}},
+		{"ohlc_4h", "rt", legacyIntervalOHLCSQL, intervalOHLCSQL, []any{lhs, rhs, 14400, 3000, false}},
+		{"ohlc_1w", "rt", legacyIntervalOHLCSQL, intervalOHLCSQL, []any{lhs, rhs, 604800, 60, false}},
+		{"ohlc_1M", "rt", legacyIntervalOHLCSQL, intervalOHLCSQL, []any{lhs, rhs, 0, 13, false}},
+		{"latest_rate", "ri", legacyLatestRateSQL, latestRateSQL, []any{1, false}},
+		{"latest_quote", "rt", legacyLatestQuoteSQL, latestQuoteSQL, []any{1, false}},
+	}
+}
+
+// queryRows returns the rows of a query as strings (floats with 12
+// significant digits, since sums may be rounded in another order)
+func queryRows(tb testing.TB, db *sql.DB, query string, args ...any) [][]string {
+	tb.Helper()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		tb.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			tb.Fatal(err)
+		}
+		row := make([]string, len(values))
+		for i, value := range values {
+			switch v := value.(type) {
+			case float64:
+				row[i] = strconv.FormatFloat(v, 'g', 12, 64)
+			case []byte:
+				row[i] = string(v)
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return result
+}
+
+func TestTypedQueriesMatchLegacy(t *testing.T) {
+	databases := map[string][2]*sql.DB{}
+	for _, kind := range []string{"ri", "rt"} {
+		databases[kind] = [2]*sql.DB{
+			syntheticDatabase(t, kind, 40, true),
+			syntheticDatabase(t, kind, 40, false),
+		}
+	}
+
+	// Ranges starting after the first log, and ending before the last one
+	for _, q := range queryComparisons("2025-01-02", "2025-02-03") {
+		t.Run(q.name, func(t *testing.T) {
+			legacy := queryRows(t, databases[q.kind][0], q.legacy, q.args...)
+			typed := queryRows(t, databases[q.kind][1], q.typed, q.args...)
+			if len(typed) == 0 {
+				t.Fatal("expected rows")
+			}
+			if !reflect.DeepEqual(legacy, typed) {
+				t.Errorf("expected %v, got %v", legacy, typed)
+			}
+		})
+	}
+}
+
+// BenchmarkQueries compares the endpoint queries on a year of synthetic
+// logs (52560 rates and 105120 quotes) between the views of the former
+// ingest scripts (legacy) and the typed columns (typed)
+func BenchmarkQueries(b *testing.B) {
+	databases := map[string][2]*sql.DB{}
+	for _, kind := range []string{"ri", "rt"} {
+		databases[kind] = [2]*sql.DB{
+			syntheticDatabase(b, kind, 365, true),
+			syntheticDatabase(b, kind, 365, false),
+		}
+	}
+
+	for _, q := range queryComparisons("2025-01-01", "2025-12-31") {
+		for i, schema := range []string{"legacy", "typed"} {
+			query := q.legacy
+			if schema == "typed" {
+				query = q.typed
+			}
+			db := databases[q.kind][i]
+			b.Run(q.name+"/"+schema, func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					rows, err := db.Query(query, q.args...)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for rows.Next() {
+					}
+					if err := rows.Err(); err != nil {
+						b.Fatal(err)
+					}
+					rows.Close()
+				}
+			})
+		}
+	}
+}
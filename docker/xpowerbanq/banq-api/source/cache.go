@@ -0,0 +1,134 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// resultCache is a bounded LRU cache of query results, whose entries are
+// invalidated when the state of their database changes or their TTL expires,
+// and which coalesces concurrent loads of the same key
+type resultCache struct {
+	mux      sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element // of *cacheEntry
+	order    *list.List               // most recently used first
+	calls    map[string]*cacheCall    // in-flight loads
+	hits     uint64
+	misses   uint64
+}
+
+// cacheEntry is a cached query result
+type cacheEntry struct {
+	key     string
+	state   dbState
+	results interface{}
+	stored  time.Time
+}
+
+// cacheCall is an in-flight load shared by concurrent misses
+type cacheCall struct {
+	done    chan struct{}
+	state   dbState
+	results interface{}
+	err     error
+}
+
+var (
+	// Query result cache (configured in main; nil disables caching)
+	queryCache *resultCache
+)
+
+// newResultCache creates a cache of up to capacity entries (zero disables
+// caching) expiring after ttl (zero never expires)
+func newResultCache(capacity int, ttl time.Duration) *resultCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &resultCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		calls:    make(map[string]*cacheCall),
+	}
+}
+
+// get returns the cached results of key for the given database state, or
+// loads them (once for all concurrent callers) and caches them on success
+func (c *resultCache) get(key string, state dbState, load func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mux.Lock()
+	if elem, exists := c.entries[key]; exists {
+		entry := elem.Value.(*cacheEntry)
+		if entry.state == state && (c.ttl <= 0 || time.Since(entry.stored) < c.ttl) {
+			c.order.MoveToFront(elem)
+			c.hits++
+			c.mux.Unlock()
+			return entry.results, nil
+		}
+		c.removeElement(elem)
+	}
+	c.misses++
+
+	// Join an in-flight load of the same key and state (and load again if
+	// it was canceled by its own client)
+	if call, exists := c.calls[key]; exists && call.state == state {
+		c.mux.Unlock()
+		<-call.done
+		if errors.Is(call.err, errQueryCanceled) {
+			return c.get(key, state, load)
+		}
+		return call.results, call.err
+	}
+
+	call := &cacheCall{done: make(chan struct{}), state: state}
+	c.calls[key] = call
+	c.mux.Unlock()
+
+	call.results, call.err = load()
+	close(call.done)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	if call.err == nil {
+		c.add(&cacheEntry{key: key, state: state, results: call.results, stored: time.Now()})
+	}
+	return call.results, call.err
+}
+
+// add inserts an entry, evicting the least recently used ones beyond capacity
+func (c *resultCache) add(entry *cacheEntry) {
+	if elem, exists := c.entries[entry.key]; exists {
+		c.removeElement(elem)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeElement removes an entry from the cache
+func (c *resultCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// stats returns the number of entries, hits and misses of the cache
+func (c *resultCache) stats() (size int, hits uint64, misses uint64) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.order.Len(), c.hits, c.misses
+}
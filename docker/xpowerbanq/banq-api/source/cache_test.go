@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	cache := newResultCache(2, 0)
+	state := dbState{DBModTime: 1, DBSize: 1}
+
+	var loads int
+	load := func(value string) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			loads++
+			return value, nil
+		}
+	}
+
+	// Misses load, hits don't
+	if v, _ := cache.get("a", state, load("a1")); v != "a1" {
+		t.Errorf("expected a1, got %v", v)
+	}
+	if v, _ := cache.get("a", state, load("a2")); v != "a1" || loads != 1 {
+		t.Errorf("expected cached a1 after 1 load, got %v after %d loads", v, loads)
+	}
+
+	// Changed database state invalidates
+	changed := dbState{DBModTime: 1, DBSize: 1, WALModTime: 2, WALSize: 1}
+	if v, _ := cache.get("a", changed, load("a3")); v != "a3" || loads != 2 {
+		t.Errorf("expected reloaded a3, got %v after %d loads", v, loads)
+	}
+
+	// Least recently used entries are evicted beyond capacity
+	cache.get("b", state, load("b1"))
+	cache.get("a", changed, load("a4")) // a is now most recently used
+	cache.get("c", state, load("c1"))   // evicts b
+	if v, _ := cache.get("b", state, load("b2")); v != "b2" {
+		t.Errorf("expected evicted b to reload, got %v", v)
+	}
+	if v, _ := cache.get("c", state, load("c2")); v != "c1" {
+		t.Errorf("expected c to stay cached, got %v", v)
+	}
+
+	size, hits, misses := cache.stats()
+	if size != 2 || hits != 3 || misses != 5 {
+		t.Errorf("expected size 2, 3 hits and 5 misses, got %d, %d, %d", size, hits, misses)
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	cache := newResultCache(10, time.Millisecond)
+	state := dbState{DBSize: 1}
+
+	cache.get("a", state, func() (interface{}, error) { return "a1", nil })
+	time.Sleep(5 * time.Millisecond)
+	if v, _ := cache.get("a", state, func() (interface{}, error) { return "a2", nil }); v != "a2" {
+		t.Errorf("expected expired entry to reload, got %v", v)
+	}
+}
+
+func TestResultCacheErrors(t *testing.T) {
+	cache := newResultCache(10, 0)
+	state := dbState{DBSize: 1}
+	failure := errors.New("failure")
+
+	if _, err := cache.get("a", state, func() (interface{}, error) { return nil, failure }); err != failure {
+		t.Errorf("expected failure, got %v", err)
+	}
+	// Errors are not cached
+	if v, err := cache.get("a", state, func() (interface{}, error) { return "a1", nil }); err != nil || v != "a1" {
+		t.Errorf("expected a1 after failure, got %v (%v)", v, err)
+	}
+}
+
+func TestResultCacheCoalescing(t *testing.T) {
+	cache := newResultCache(10, 0)
+	state := dbState{DBSize: 1}
+
+	var loads int32
+	release := make(chan struct{})
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.get("a", state, load)
+		}(i)
+	}
+
+	// Wait for all callers to be either loading or waiting
+	for {
+		if _, _, misses := cache.stats(); misses == uint64(len(results)) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("expected concurrent misses to load once, got %d loads", loads)
+	}
+	for i, v := range results {
+		if v != "value" {
+			t.Errorf("caller %d: expected value, got %v", i, v)
+		}
+	}
+}
+
+func TestResultCacheCanceledLoad(t *testing.T) {
+	cache := newResultCache(10, 0)
+	state := dbState{DBSize: 1}
+
+	// The first caller's load is canceled by its client while another waits
+	release := make(chan struct{})
+	canceled := make(chan interface{})
+	go func() {
+		results, _ := cache.get("a", state, func() (interface{}, error) {
+			<-release
+			return nil, errQueryCanceled
+		})
+		canceled <- results
+	}()
+	for {
+		if _, _, misses := cache.stats(); misses == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	waiting := make(chan interface{})
+	go func() {
+		results, err := cache.get("a", state, func() (interface{}, error) { return "value", nil })
+		if err != nil {
+			t.Errorf("expected waiting caller to load again, got %v", err)
+		}
+		waiting <- results
+	}()
+	for {
+		if _, _, misses := cache.stats(); misses == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if v := <-canceled; v != nil {
+		t.Errorf("expected no results for canceled caller, got %v", v)
+	}
+	if v := <-waiting; v != "value" {
+		t.Errorf("expected waiting caller to get value, got %v", v)
+	}
+}
+
+func TestResultCacheDisabled(t *testing.T) {
+	cache := newResultCache(0, time.Minute)
+	if cache != nil {
+		t.Fatalf("expected nil cache for zero capacity")
+	}
+
+	var loads int
+	for i := 0; i < 2; i++ {
+		cache.get("a", dbState{}, func() (interface{}, error) { loads++; return "a", nil })
+	}
+	if loads != 2 {
+		t.Errorf("expected disabled cache to always load, got %d loads", loads)
+	}
+	if size, hits, misses := cache.stats(); size != 0 || hits != 0 || misses != 0 {
+		t.Errorf("expected empty stats, got %d, %d, %d", size, hits, misses)
+	}
+}
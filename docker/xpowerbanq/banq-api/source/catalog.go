@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DatabaseInfo represents a catalog entry describing a database
+type DatabaseInfo struct {
+	Name string `json:"name"`
+	DatabaseName
+	Rows       int64   `json:"rows"`
+	FirstTime  *string `json:"first_time"`
+	LastTime   *string `json:"last_time"`
+	FirstBlock *int64  `json:"first_block"`
+	LastBlock  *int64  `json:"last_block"`
+	FileSize   int64   `json:"file_size"`
+	WALSize    int64   `json:"wal_size"`
+}
+
+// catalogEntry is a cached DatabaseInfo with the file state it was built from
+type catalogEntry struct {
+	state dbState
+	info  DatabaseInfo
+}
+
+var (
+	// Database catalog cache (by database name)
+	catalog    = make(map[string]catalogEntry)
+	catalogMux sync.Mutex
+)
+
+// listDatabases returns the catalog of all ri_ and rt_ databases in dbPath,
+// refreshing the entries of database files that changed since last listed
+func listDatabases() ([]DatabaseInfo, error) {
+	dbFiles, err := filepath.Glob(filepath.Join(dbPath, "*.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list database files: %v", err)
+	}
+
+	catalogMux.Lock()
+	defer catalogMux.Unlock()
+
+	infos := make([]DatabaseInfo, 0, len(dbFiles))
+	listed := make(map[string]bool, len(dbFiles))
+	for _, dbFile := range dbFiles {
+		dbName := strings.TrimSuffix(filepath.Base(dbFile), ".db")
+		prefix := catalogPrefix(dbName)
+		if prefix == "" {
+			continue
+		}
+		listed[dbName] = true
+
+		state, err := databaseState(dbName)
+		if err != nil {
+			slog.Error("Catalog error", "db", dbName, "err", err)
+			continue
+		}
+		if entry, exists := catalog[dbName]; exists && entry.state == state {
+			infos = append(infos, entry.info)
+			continue
+		}
+
+		info, err := describeDatabase(dbName, prefix, state)
+		if err != nil {
+			// List without statistics, and retry on next listing
+			slog.Error("Catalog error", "db", dbName, "err", err)
+			infos = append(infos, info)
+			delete(catalog, dbName)
+			continue
+		}
+		catalog[dbName] = catalogEntry{state: state, info: info}
+		infos = append(infos, info)
+	}
+
+	// Forget removed databases
+	for dbName := range catalog {
+		if !listed[dbName] {
+			delete(catalog, dbName)
+		}
+	}
+
+	return infos, nil
+}
+
+// forgetCatalog drops the catalog entry of a database
+func forgetCatalog(dbName string) {
+	catalogMux.Lock()
+	defer catalogMux.Unlock()
+	delete(catalog, dbName)
+}
+
+// catalogPrefix returns the catalog prefix of a database name (or "")
+func catalogPrefix(dbName string) string {
+	for prefix := range catalogSQL {
+		if dbPrefixed(dbName, prefix) == nil {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// describeDatabase builds the catalog entry of a database
+func describeDatabase(dbName string, prefix string, state dbState) (DatabaseInfo, error) {
+	info := DatabaseInfo{
+		Name:     dbName,
+		FileSize: state.DBSize,
+		WALSize:  state.WALSize,
+	}
+	if name, err := parseDatabaseName(dbName); err == nil {
+		info.DatabaseName = name
+	} else {
+		info.Kind = strings.TrimSuffix(prefix, "_")
+	}
+
+	db, _, release, err := getDatabase(dbName)
+	if err != nil {
+		return info, err
+	}
+	defer release()
+	err = db.QueryRow(catalogSQL[prefix]).Scan(
+		&info.Rows, &info.FirstTime, &info.LastTime, &info.FirstBlock, &info.LastBlock,
+	)
+	return info, err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestListDatabases(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_apow_supply_0",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "600000000000000000", "1000000100000000000000000000", 1763341200, 105, 0),
+	)
+	createTestDatabase(t, tempDir, "rt_apow_xpow_0",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 200, 0),
+	)
+	createTestDatabase(t, tempDir, "ri_test_catalog")
+	// Databases without ri_/rt_ prefix are not listed
+	if err := os.WriteFile(filepath.Join(tempDir, "other.db"), nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	infos, err := listDatabases()
+	if err != nil {
+		t.Fatalf("listDatabases failed: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("expected 3 databases, got %d: %+v", len(infos), infos)
+	}
+
+	byName := make(map[string]DatabaseInfo)
+	for _, info := range infos {
+		byName[info.Name] = info
+	}
+
+	ri := byName["ri_apow_supply_0"]
+	if ri.Kind != "ri" || ri.Token != "APOW" || ri.Mode != "supply" || ri.Pool != "P000" {
+		t.Errorf("unexpected parsed name: %+v", ri.DatabaseName)
+	}
+	if ri.Rows != 2 || *ri.FirstTime != "2025-11-15 09:00:00" || *ri.LastTime != "2025-11-17 01:00:00" ||
+		*ri.FirstBlock != 100 || *ri.LastBlock != 105 {
+		t.Errorf("unexpected statistics: %+v", ri)
+	}
+	if ri.FileSize == 0 {
+		t.Errorf("expected file size, got 0")
+	}
+
+	rt := byName["rt_apow_xpow_0"]
+	if rt.Kind != "rt" || rt.Source != "APOW" || rt.Target != "XPOW" || rt.Oracle != "T000" || rt.Rows != 1 {
+		t.Errorf("unexpected rt_ entry: %+v", rt)
+	}
+
+	// Unparsable names keep their kind, and empty databases have no range
+	empty := byName["ri_test_catalog"]
+	if empty.Kind != "ri" || empty.Token != "" || empty.Rows != 0 || empty.FirstTime != nil {
+		t.Errorf("unexpected empty entry: %+v", empty)
+	}
+
+	// Changed databases are refreshed
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "rt_apow_xpow_0.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO raw_logs(id, json) VALUES('q2', ?)",
+		testQuoteLine("q2", "100000000000000000000", "110000000000000000000", 1763341200, 201, 0))
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to insert row: %v", err)
+	}
+
+	// Removed databases are forgotten
+	os.Remove(filepath.Join(tempDir, "ri_test_catalog.db"))
+
+	infos, err = listDatabases()
+	if err != nil {
+		t.Fatalf("listDatabases failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 databases after removal, got %d", len(infos))
+	}
+	for _, info := range infos {
+		if info.Name == "rt_apow_xpow_0" && (info.Rows != 2 || *info.LastBlock != 201) {
+			t.Errorf("expected refreshed statistics, got %+v", info)
+		}
+	}
+	if _, exists := catalog["ri_test_catalog"]; exists {
+		t.Errorf("expected removed database to be evicted from catalog")
+	}
+}
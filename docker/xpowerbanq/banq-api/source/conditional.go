@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cacheControl returns the Cache-Control policy of a response: date ranges
+// ending before today (UTC) are immutable, ranges including today are short
+// lived, and other responses use the route's max-age (default: 3600)
+func cacheControl(r *http.Request, config *RouteConfig) string {
+	if rhs := r.URL.Query().Get("rhs"); rhs != "" {
+		if rhs < time.Now().UTC().Format(time.DateOnly) {
+			return fmt.Sprintf("public, max-age=%d, immutable", immutableMaxAge)
+		}
+		return fmt.Sprintf("public, max-age=%d", currentMaxAge)
+	}
+	if config.CacheMaxAge > 0 {
+		return fmt.Sprintf("public, max-age=%d", config.CacheMaxAge)
+	}
+	return "public, max-age=3600"
+}
+
+// validators derives the ETag and Last-Modified validators of a response
+// from the state of its database and the request path and parameters
+func validators(r *http.Request, state dbState) (string, time.Time) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s?%s\n%d:%d:%d:%d", r.URL.Path, r.URL.Query().Encode(),
+		state.DBModTime, state.DBSize, state.WALModTime, state.WALSize)
+	etag := `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	return etag, state.modTime().UTC().Truncate(time.Second)
+}
+
+// notModified reports whether the client's cached copy is still current
+// (If-None-Match takes precedence over If-Modified-Since)
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if since, err := http.ParseTime(ims); err == nil {
+			return !lastModified.After(since)
+		}
+	}
+	return false
+}
+
+// cacheHeaders are the caching headers of a response from a database in a
+// given state; they are written with successful responses only, so that
+// errors are never cached
+type cacheHeaders struct {
+	cacheControl string
+	etag         string
+	lastModified time.Time
+}
+
+// newCacheHeaders derives the caching headers of a response from a database
+// in the given state with the given Cache-Control policy
+func newCacheHeaders(r *http.Request, state dbState, cacheControl string) cacheHeaders {
+	// Responses to API keys must not be served to others by shared caches
+	if requestClient(r) != nil {
+		cacheControl = strings.Replace(cacheControl, "public", "private", 1)
+	}
+	etag, lastModified := validators(r, state)
+	return cacheHeaders{cacheControl: cacheControl, etag: etag, lastModified: lastModified}
+}
+
+// mutable returns the headers with a short lifetime instead of immutable
+// caching, for responses that may still change (e.g. empty past ranges,
+// which backfills or reorgs can fill)
+func (h cacheHeaders) mutable() cacheHeaders {
+	if strings.Contains(h.cacheControl, "immutable") {
+		visibility, _, _ := strings.Cut(h.cacheControl, ",")
+		h.cacheControl = fmt.Sprintf("%s, max-age=%d", visibility, currentMaxAge)
+	}
+	return h
+}
+
+// write sets the caching headers of a response
+func (h cacheHeaders) write(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", h.cacheControl)
+	w.Header().Set("ETag", h.etag)
+	w.Header().Set("Last-Modified", h.lastModified.Format(http.TimeFormat))
+}
+
+// checkConditional answers 304 Not Modified if the client's cached copy is
+// current (returns true); immutable copies are not revalidated, so a client
+// revalidating a past range holds a mutable (empty) one, which stays mutable
+func checkConditional(w http.ResponseWriter, r *http.Request, headers cacheHeaders) bool {
+	if notModified(r, headers.etag, headers.lastModified) {
+		headers.mutable().write(w)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCacheControl(t *testing.T) {
+	today := time.Now().UTC().Format(time.DateOnly)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+
+	tests := []struct {
+		name     string
+		query    string
+		config   *RouteConfig
+		expected string
+	}{
+		{"range in the past", "?lhs=2025-01-01&rhs=" + yesterday, &RouteConfig{},
+			"public, max-age=31536000, immutable"},
+		{"range including today", "?lhs=2025-01-01&rhs=" + today, &RouteConfig{},
+			"public, max-age=60"},
+		{"range in the future", "?lhs=2025-01-01&rhs=2999-12-31", &RouteConfig{},
+			"public, max-age=60"},
+		{"no range", "", &RouteConfig{}, "public, max-age=3600"},
+		{"no range with route max-age", "", &RouteConfig{CacheMaxAge: 10}, "public, max-age=10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			if got := cacheControl(req, tt.config); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidators(t *testing.T) {
+	state := dbState{DBModTime: time.Date(2025, 11, 15, 9, 0, 0, 500, time.UTC).UnixNano(), DBSize: 4096}
+	req := httptest.NewRequest(http.MethodGet, "/ri_x/daily_average.json?lhs=2025-11-15&rhs=2025-12-15", nil)
+
+	etag, lastModified := validators(req, state)
+	if !lastModified.Equal(time.Date(2025, 11, 15, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected Last-Modified truncated to seconds, got %v", lastModified)
+	}
+
+	// Parameter order does not matter
+	reordered := httptest.NewRequest(http.MethodGet, "/ri_x/daily_average.json?rhs=2025-12-15&lhs=2025-11-15", nil)
+	if other, _ := validators(reordered, state); other != etag {
+		t.Errorf("expected same ETag for reordered parameters")
+	}
+
+	// Parameters and database state change the ETag
+	otherParams := httptest.NewRequest(http.MethodGet, "/ri_x/daily_average.json?lhs=2025-11-16&rhs=2025-12-15", nil)
+	if other, _ := validators(otherParams, state); other == etag {
+		t.Errorf("expected different ETag for different parameters")
+	}
+	walState := state
+	walState.WALSize = 8192
+	if other, _ := validators(req, walState); other == etag {
+		t.Errorf("expected different ETag for different WAL state")
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := `W/"abc"`
+	lastModified := time.Date(2025, 11, 15, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"no conditions", nil, false},
+		{"matching ETag", map[string]string{"If-None-Match": `W/"abc"`}, true},
+		{"matching strong ETag", map[string]string{"If-None-Match": `"abc"`}, true},
+		{"matching ETag in list", map[string]string{"If-None-Match": `"xyz", W/"abc"`}, true},
+		{"wildcard ETag", map[string]string{"If-None-Match": `*`}, true},
+		{"stale ETag", map[string]string{"If-None-Match": `W/"xyz"`}, false},
+		{"unmodified since", map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{
+			"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}, false},
+		{"ETag takes precedence", map[string]string{
+			"If-None-Match": `W/"xyz"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)}, false},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := notModified(req, etag, lastModified); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// Integration test with real database for conditional requests
+func TestConditionalIntegration(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_conditional",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet,
+			"/ri_test_conditional/daily_average.json?lhs=2025-11-15&rhs=2025-12-15", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(nil)
+	etag, lastModified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("expected 200 with validators, got %d (ETag %q, Last-Modified %q)", rr.Code, etag, lastModified)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Errorf("expected immutable Cache-Control for past range, got %q", cc)
+	}
+
+	if rr = get(map[string]string{"If-None-Match": etag}); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("expected 304 without body for matching ETag, got %d", rr.Code)
+	}
+	if rr = get(map[string]string{"If-Modified-Since": lastModified}); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for If-Modified-Since, got %d", rr.Code)
+	}
+
+	// Changing the database invalidates the ETag
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "ri_test_conditional.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO raw_logs(id, json) VALUES('r2', ?)",
+		testRateLine("r2", "600000000000000000", "1000000000000000000000000000", 1763197300, 101, 0))
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to insert row: %v", err)
+	}
+
+	if rr = get(map[string]string{"If-None-Match": etag}); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 after database change, got %d", rr.Code)
+	}
+}
+
+// Errors are never cached, and empty past ranges are not immutable
+func TestConditionalErrorsAndEmptyRanges(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_uncached")
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/ri_test_uncached/latest.json", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without data, got %d", rr.Code)
+	}
+	if cc, etag := rr.Header().Get("Cache-Control"), rr.Header().Get("ETag"); cc != "no-store" || etag != "" {
+		t.Errorf("expected uncached 404, got Cache-Control %q and ETag %q", cc, etag)
+	}
+
+	path := "/ri_test_uncached/daily_average.json?lhs=2025-11-15&rhs=2025-12-15"
+	rr = get(path, nil)
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" || etag == "" {
+		t.Fatalf("expected 200 with an empty range and validators, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("expected short Cache-Control for empty past range, got %q", cc)
+	}
+
+	// Revalidated copies stay short lived
+	rr = get(path, map[string]string{"If-None-Match": etag})
+	if rr.Code != http.StatusNotModified || rr.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("expected 304 with short Cache-Control, got %d (%q)", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+}
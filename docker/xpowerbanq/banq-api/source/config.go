@@ -1,6 +1,9 @@
 package main
 
-import "regexp"
+import (
+	"regexp"
+	"time"
+)
 
 var (
 	// Configuration values (can be set via command-line arguments)
@@ -8,6 +11,75 @@ var (
 	dbPath     = "/srv/db"
 	listenPort = "8001"
 
+	// Separate listen address of /metrics (empty: not served)
+	metricsAddr = ""
+
+	// Log output format (text or json)
+	logFormat = "text"
+
+	// HTTP server timeouts, and deadline to drain in-flight requests on
+	// shutdown (below the 5s grace period of docker stop -t 5)
+	readTimeout     = 15 * time.Second
+	writeTimeout    = 60 * time.Second
+	idleTimeout     = 120 * time.Second
+	shutdownTimeout = 4 * time.Second
+
+	// Maximum duration of database queries (unless set per route), below
+	// the write timeout so that timed out queries are still answered
+	queryTimeout = 30 * time.Second
+
+	// API information reported by the root endpoint and the OpenAPI spec
+	apiTitle       = "XPower Banq Database API"
+	apiDescription = "Read-only API for XPower Banq utilization rates and price quotes"
+	apiVersion     = "1.0.0"
+
+	// Server-Sent Events polling and heartbeat intervals, and the number of
+	// removed events remembered per stream to skip repeated retractions
+	streamPollInterval = time.Second
+	streamHeartbeat    = 15 * time.Second
+	streamMaxRetracted = 4096
+
+	// Interval of rescanning dbPath for added, removed or replaced database
+	// files (zero disables polling; SIGHUP always forces a rescan)
+	watchInterval = 10 * time.Second
+
+	// Maximum age of the newest record per database name prefix before
+	// readiness fails (zero disables the check)
+	maxStaleness = map[string]time.Duration{
+		"ri_": 24 * time.Hour,
+		"rt_": 2 * time.Hour,
+	}
+
+	// Directory of declarative query files (*.sql) served as endpoints
+	// (empty: none)
+	queriesPath = ""
+
+	// Query result cache (max entries, zero disables it) and entry lifetime
+	cacheSize = 256
+	cacheTTL  = 10 * time.Minute
+
+	// Cache lifetimes (in seconds) of date ranges in the past vs. including today
+	immutableMaxAge = 31536000
+	currentMaxAge   = 60
+
+	// Rate limit of API requests per client IP (tokens per second, zero
+	// disables it) and burst; routes take one token or their Cost
+	rateLimit = 100.0
+	rateBurst = 200
+
+	// Proxies trusted to set the client IP in X-Real-IP or X-Forwarded-For
+	// (loopback and the default Docker bridge networks)
+	trustedProxies = mustParseNetworks("127.0.0.0/8", "::1/128", "172.16.0.0/12")
+
+	// API key file of partner tiers (empty: API keys disabled)
+	apiKeysPath = ""
+
+	// Block gaps reported as suspicious by coverage reports: wider than
+	// coverageGapFactor times the median blocks between events, and longer
+	// than coverageMinGap (so that quiet hours of a busy market pass)
+	coverageGapFactor = 10.0
+	coverageMinGap    = time.Hour
+
 	// CORS allowed origins
 	allowedOrigins = map[string]bool{
 		"https://www.xpowermine.com": true,
@@ -18,36 +90,279 @@ var (
 	// Date validation regex (YYYY-MM-DD)
 	dateRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
 
-	// SQL queries hardcoded for security
+	// Date or time validation regex (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS), so
+	// that pages of intraday rows continue at the time of the next row
+	dateTimeRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(?: \d{2}:\d{2}:\d{2})?$`)
+
+	// Candle interval validation regex (e.g. 1h, 4h, 1d, 1w or 1M)
+	intervalRegex = regexp.MustCompile(`^(?:([1-9]\d?)([hdw])|1M)$`)
+
+	// Query parameters configuration (parsers and API docs formats)
+	queryParams = map[string]*ParamConfig{
+		"lhs": {Parse: dateTimeFrom, Format: "YYYY-MM-DD[ HH:MM:SS]",
+			Schema: map[string]interface{}{"type": "string", "pattern": dateTimeRegex.String()}},
+		"rhs": {Parse: dateFrom, Format: "YYYY-MM-DD",
+			Schema: map[string]interface{}{"type": "string", "format": "date"}},
+		"interval": {Parse: intervalFrom, Format: "1h|4h|1d|1w|1M",
+			Schema: map[string]interface{}{"type": "string", "pattern": intervalRegex.String()}},
+		"cursor": {Parse: cursorFrom, Format: "CURSOR", Optional: true,
+			Schema: map[string]interface{}{"type": "string"}},
+		"limit": {Parse: limitFrom, Format: "N", Optional: true,
+			Schema: map[string]interface{}{"type": "integer", "minimum": 1}},
+	}
+
+	// SQL queries hardcoded for security; they range-scan the typed columns
+	// of raw_logs on unix times from the start of day ?1 to the end of day
+	// ?2, and the argument after the row limit includes logs removed by
+	// chain reorgs (excluded by default)
 	dailyAverageSQL = `
-		SELECT avg(util_e18) AS avg_util, date(stamp_iso) AS day, count(*) AS n
-		FROM riw_view
-		WHERE stamp_iso > ? AND stamp_iso <= ? || ' 23:59:59'
-		GROUP BY day
-		ORDER BY day
-		LIMIT ?`
+		SELECT avg(util_e18) AS avg_util, date(stamp_unix / 86400 * 86400, 'unixepoch') AS day, count(*) AS n
+		FROM raw_logs
+		WHERE stamp_unix >= unixepoch(?1) AND stamp_unix < unixepoch(?2, '+1 day')
+			AND (?4 OR log_removed IS NOT 1)
+		GROUP BY stamp_unix / 86400
+		ORDER BY stamp_unix / 86400
+		LIMIT ?3`
 
+	// Aggregates quotes per day, and looks up the mid of the first and last
+	// quote of each day only (by index, ties broken by block and log index)
 	dailyOHLCSQL = `
-		WITH ranked_quotes AS (
+		WITH daily_quotes AS (
+			SELECT
+				MIN(quote_time_unix) AS open_time,
+				MAX(quote_time_unix) AS close_time,
+				MAX((quote_bid_e18+quote_ask_e18)/2) AS high,
+				MIN((quote_bid_e18+quote_ask_e18)/2) AS low,
+				quote_time_unix / 86400 AS day_number,
+				COUNT(*) AS n
+			FROM raw_logs
+			WHERE quote_time_unix >= unixepoch(?1) AND quote_time_unix < unixepoch(?2, '+1 day')
+				AND (?4 OR log_removed IS NOT 1)
+			GROUP BY day_number
+			ORDER BY day_number
+			LIMIT ?3
+		)
+		SELECT
+			(SELECT (quote_bid_e18+quote_ask_e18)/2 FROM raw_logs
+				WHERE quote_time_unix = open_time AND (?4 OR log_removed IS NOT 1)
+				ORDER BY log_block_number, log_index LIMIT 1) AS open,
+			high,
+			low,
+			(SELECT (quote_bid_e18+quote_ask_e18)/2 FROM raw_logs
+				WHERE quote_time_unix = close_time AND (?4 OR log_removed IS NOT 1)
+				ORDER BY log_block_number DESC, log_index DESC LIMIT 1) AS close,
+			date(day_number * 86400, 'unixepoch') AS day,
+			n
+		FROM daily_quotes
+		ORDER BY day_number`
+
+	// Aggregates rates per day, and extracts the big integer indexes of the
+	// first and last rate of each day only
+	dailyRateSQL = `
+		WITH daily_rates AS (
+			SELECT
+				MIN(stamp_unix) AS first_stamp,
+				MAX(stamp_unix) AS last_stamp,
+				stamp_unix / 86400 AS day_number,
+				COUNT(*) AS n
+			FROM raw_logs
+			WHERE stamp_unix >= unixepoch(?1) AND stamp_unix < unixepoch(?2, '+1 day')
+				AND (?4 OR log_removed IS NOT 1)
+			GROUP BY day_number
+			ORDER BY day_number
+			LIMIT ?3
+		)
+		SELECT
+			(SELECT REPLACE(json_extract(json,'$.index_ray'),'n','') FROM raw_logs
+				WHERE stamp_unix = first_stamp AND (?4 OR log_removed IS NOT 1)
+				ORDER BY log_block_number, log_index LIMIT 1) AS first_index,
+			(SELECT REPLACE(json_extract(json,'$.index_ray'),'n','') FROM raw_logs
+				WHERE stamp_unix = last_stamp AND (?4 OR log_removed IS NOT 1)
+				ORDER BY log_block_number DESC, log_index DESC LIMIT 1) AS last_index,
+			first_stamp,
+			last_stamp,
+			date(day_number * 86400, 'unixepoch') AS day,
+			n
+		FROM daily_rates
+		ORDER BY day_number`
+
+	// Buckets quotes by ?3 seconds (weeks start on Monday), or by calendar
+	// month if ?3 is zero
+	intervalOHLCSQL = `
+		WITH bucketed_quotes AS (
 			SELECT
-				(quote_bid_e18+quote_ask_e18)/2 AS mid,
-				date(quote_time_iso) AS day,
-				ROW_NUMBER() OVER (PARTITION BY date(quote_time_iso) ORDER BY quote_time_iso ASC) AS rn_beg,
-				ROW_NUMBER() OVER (PARTITION BY date(quote_time_iso) ORDER BY quote_time_iso DESC) AS rn_end
-			FROM rtw_view
-			WHERE quote_time_iso > ? AND quote_time_iso <= ? || ' 23:59:59'
+				MIN(quote_time_unix) AS open_time,
+				MAX(quote_time_unix) AS close_time,
+				MAX((quote_bid_e18+quote_ask_e18)/2) AS high,
+				MIN((quote_bid_e18+quote_ask_e18)/2) AS low,
+				CASE WHEN ?3 = 0
+					THEN unixepoch(quote_time_unix, 'unixepoch', 'start of month')
+					ELSE (quote_time_unix - (CASE WHEN ?3 % 604800 = 0 THEN 345600 ELSE 0 END)) / ?3 * ?3
+						+ (CASE WHEN ?3 % 604800 = 0 THEN 345600 ELSE 0 END)
+				END AS bucket,
+				COUNT(*) AS n
+			FROM raw_logs
+			WHERE quote_time_unix >= unixepoch(?1) AND quote_time_unix < unixepoch(?2, '+1 day')
+				AND (?5 OR log_removed IS NOT 1)
+			GROUP BY bucket
+			ORDER BY bucket
+			LIMIT ?4
 		)
 		SELECT
-			MAX(CASE WHEN rn_beg = 1 THEN mid END) AS open,
-			MAX(mid) AS high,
-			MIN(mid) AS low,
-			MAX(CASE WHEN rn_end = 1 THEN mid END) AS close,
-			day,
-			COUNT(*) AS n
-		FROM ranked_quotes
-		GROUP BY day
-		ORDER BY day
-		LIMIT ?`
+			(SELECT (quote_bid_e18+quote_ask_e18)/2 FROM raw_logs
+				WHERE quote_time_unix = open_time AND (?5 OR log_removed IS NOT 1)
+				ORDER BY log_block_number, log_index LIMIT 1) AS open,
+			high,
+			low,
+			(SELECT (quote_bid_e18+quote_ask_e18)/2 FROM raw_logs
+				WHERE quote_time_unix = close_time AND (?5 OR log_removed IS NOT 1)
+				ORDER BY log_block_number DESC, log_index DESC LIMIT 1) AS close,
+			datetime(bucket, 'unixepoch') AS time,
+			n
+		FROM bucketed_quotes
+		ORDER BY bucket`
+
+	// Raw events after the (block number, log index) cursor ?1, ?2
+	rateEventsSQL = `
+		SELECT
+			log_block_number, log_index, log_tx_hash, id,
+			util_e18, REPLACE(json_extract(json,'$.util_wad'),'n',''),
+			index_e27, REPLACE(json_extract(json,'$.index_ray'),'n',''),
+			datetime(stamp_unix, 'unixepoch')
+		FROM raw_logs
+		WHERE log_block_number >= ?1 AND (log_block_number > ?1 OR log_index > ?2)
+			AND (?4 OR log_removed IS NOT 1)
+		ORDER BY log_block_number, log_index
+		LIMIT ?3`
+
+	quoteEventsSQL = `
+		SELECT
+			log_block_number, log_index, log_tx_hash, id,
+			quote_bid_e18, REPLACE(json_extract(json,'$.quote_bid'),'n',''),
+			quote_ask_e18, REPLACE(json_extract(json,'$.quote_ask'),'n',''),
+			datetime(quote_time_unix, 'unixepoch')
+		FROM raw_logs
+		WHERE log_block_number >= ?1 AND (log_block_number > ?1 OR log_index > ?2)
+			AND (?4 OR log_removed IS NOT 1)
+		ORDER BY log_block_number, log_index
+		LIMIT ?3`
+
+	// Most recent row (ties broken by block number and log index); the row
+	// limit ?1 is bound like for every route, but not needed
+	latestRateSQL = `
+		SELECT util_e18, index_e27, datetime(stamp_unix, 'unixepoch')
+		FROM raw_logs
+		WHERE ?2 OR log_removed IS NOT 1
+		ORDER BY stamp_unix DESC, log_block_number DESC, log_index DESC
+		LIMIT 1`
+
+	latestQuoteSQL = `
+		SELECT
+			quote_bid_e18, quote_ask_e18,
+			(quote_bid_e18+quote_ask_e18)/2 AS mid,
+			datetime(quote_time_unix, 'unixepoch')
+		FROM raw_logs
+		WHERE ?2 OR log_removed IS NOT 1
+		ORDER BY quote_time_unix DESC, log_block_number DESC, log_index DESC
+		LIMIT 1`
+
+	// Stream rows after rowid ?1, including removed logs (views have no
+	// rowid, so these query raw_logs like the events queries)
+	rateStreamSQL = `
+		SELECT
+			rowid, log_block_number, log_index, log_tx_hash, id,
+			util_e18, REPLACE(json_extract(json,'$.util_wad'),'n',''),
+			index_e27, REPLACE(json_extract(json,'$.index_ray'),'n',''),
+			datetime(stamp_unix, 'unixepoch'), log_removed IS 1
+		FROM raw_logs
+		WHERE rowid > ?1
+		ORDER BY rowid
+		LIMIT ?2`
+
+	quoteStreamSQL = `
+		SELECT
+			rowid, log_block_number, log_index, log_tx_hash, id,
+			quote_bid_e18, REPLACE(json_extract(json,'$.quote_bid'),'n',''),
+			quote_ask_e18, REPLACE(json_extract(json,'$.quote_ask'),'n',''),
+			datetime(quote_time_unix, 'unixepoch'), log_removed IS 1
+		FROM raw_logs
+		WHERE rowid > ?1
+		ORDER BY rowid
+		LIMIT ?2`
+
+	// Removed logs of the blocks ?1 to ?2 up to rowid ?3, i.e. streamed logs
+	// tombstoned by a reorg once the logs of their new blocks arrive
+	streamRemovedSQL = `
+		SELECT rowid, log_block_number, log_index, log_tx_hash, id
+		FROM raw_logs
+		WHERE log_block_number BETWEEN ?1 AND ?2 AND rowid <= ?3 AND log_removed IS 1
+		ORDER BY rowid`
+
+	streamTailSQL = `SELECT COALESCE(MAX(rowid), 0) FROM raw_logs`
+
+	// Catalog statistics per database name prefix (without removed logs)
+	catalogSQL = map[string]string{
+		"ri_": `
+			SELECT COUNT(*), datetime(MIN(stamp_unix), 'unixepoch'), datetime(MAX(stamp_unix), 'unixepoch'),
+				MIN(log_block_number), MAX(log_block_number)
+			FROM raw_logs
+			WHERE log_removed IS NOT 1`,
+		"rt_": `
+			SELECT COUNT(*), datetime(MIN(quote_time_unix), 'unixepoch'), datetime(MAX(quote_time_unix), 'unixepoch'),
+				MIN(log_block_number), MAX(log_block_number)
+			FROM raw_logs
+			WHERE log_removed IS NOT 1`,
+	}
+
+	// Coverage queries per database name prefix (without removed logs)
+	coverageSQL = map[string]coverageQueries{
+		"ri_": {
+			Counts: `
+				SELECT COUNT(*), COALESCE(SUM(log_removed IS 1), 0)
+				FROM raw_logs`,
+			Blocks: `
+				SELECT log_block_number, datetime(MIN(stamp_unix), 'unixepoch'), COUNT(*)
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND log_block_number IS NOT NULL
+				GROUP BY log_block_number
+				ORDER BY log_block_number`,
+			Days: `
+				SELECT DISTINCT date(stamp_unix / 86400 * 86400, 'unixepoch') AS day
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND stamp_unix IS NOT NULL
+				ORDER BY day`,
+			Duplicates: `
+				SELECT log_tx_hash, log_index, group_concat(id, ' ')
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND log_tx_hash IS NOT NULL
+				GROUP BY log_tx_hash, log_index
+				HAVING COUNT(DISTINCT json) > 1
+				ORDER BY MIN(log_block_number), log_index`,
+		},
+		"rt_": {
+			Counts: `
+				SELECT COUNT(*), COALESCE(SUM(log_removed IS 1), 0)
+				FROM raw_logs`,
+			Blocks: `
+				SELECT log_block_number, datetime(MIN(quote_time_unix), 'unixepoch'), COUNT(*)
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND log_block_number IS NOT NULL
+				GROUP BY log_block_number
+				ORDER BY log_block_number`,
+			Days: `
+				SELECT DISTINCT date(quote_time_unix / 86400 * 86400, 'unixepoch') AS day
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND quote_time_unix IS NOT NULL
+				ORDER BY day`,
+			Duplicates: `
+				SELECT log_tx_hash, log_index, group_concat(id, ' ')
+				FROM raw_logs
+				WHERE log_removed IS NOT 1 AND log_tx_hash IS NOT NULL
+				GROUP BY log_tx_hash, log_index
+				HAVING COUNT(DISTINCT json) > 1
+				ORDER BY MIN(log_block_number), log_index`,
+		},
+	}
 
 	// API endpoint routes configuration
 	endpointRoutes = map[string]*RouteConfig{
@@ -56,16 +371,84 @@ var (
 			SQL:           dailyAverageSQL,
 			QueryParams:   []string{"lhs", "rhs"},
 			ResultScanner: scanDailyAverage,
+			Response:      []DailyAverage{},
 			Description:   "Daily average utilization rates",
 			Example:       "/ri_apow_supply_0/daily_average.json?lhs=2025-11-15&rhs=2025-12-15",
 		},
+		"/daily_rate.json": {
+			DBPrefix:      "ri_",
+			SQL:           dailyRateSQL,
+			QueryParams:   []string{"lhs", "rhs"},
+			ResultScanner: scanDailyRate,
+			Response:      []DailyRate{},
+			Description:   "Daily annualized rates (APR/APY) derived from index growth",
+			Example:       "/ri_apow_supply_0/daily_rate.json?lhs=2025-11-15&rhs=2025-12-15",
+		},
 		"/daily_ohlc.json": {
 			DBPrefix:      "rt_",
 			SQL:           dailyOHLCSQL,
 			QueryParams:   []string{"lhs", "rhs"},
 			ResultScanner: scanDailyOHLC,
+			Response:      []DailyOHLC{},
 			Description:   "Daily OHLC price quotes",
 			Example:       "/rt_apow_xpow_0/daily_ohlc.json?lhs=2025-11-15&rhs=2025-12-15",
+			Cost:          2,
+		},
+		"/ohlc.json": {
+			DBPrefix:      "rt_",
+			SQL:           intervalOHLCSQL,
+			QueryParams:   []string{"lhs", "rhs", "interval"},
+			ResultScanner: scanIntervalOHLC,
+			Response:      []IntervalOHLC{},
+			Description:   "OHLC price quotes per candle interval",
+			Example:       "/rt_apow_xpow_0/ohlc.json?lhs=2025-11-15&rhs=2025-12-15&interval=4h",
+			Cost:          5, // up to 24 candles per day (vs. one of daily_ohlc)
+		},
+		"/latest.json": {
+			Description: "Latest utilization rate or price quote",
+			Example:     "/rt_apow_xpow_0/latest.json",
+			CacheMaxAge: 60,
+			Variants: []*RouteConfig{
+				{DBPrefix: "ri_", SQL: latestRateSQL, ResultScanner: scanLatestRate, Response: LatestRate{}},
+				{DBPrefix: "rt_", SQL: latestQuoteSQL, ResultScanner: scanLatestQuote, Response: LatestQuote{}},
+			},
+		},
+		"/stream": {
+			Description:    "Server-Sent Events of newly ingested utilization rates or price quotes (and their removals by chain reorgs)",
+			Example:        "/rt_apow_xpow_0/stream",
+			MediaType:      "text/event-stream",
+			Handler:        handleStream,
+			IncludeRemoved: true,
+			Variants: []*RouteConfig{
+				// Tail queries run every poll interval, so they must not linger
+				{DBPrefix: "ri_", SQL: rateStreamSQL, ResultScanner: scanRateStream, Response: RateEvent{},
+					Timeout: 5 * time.Second},
+				{DBPrefix: "rt_", SQL: quoteStreamSQL, ResultScanner: scanQuoteStream, Response: QuoteEvent{},
+					Timeout: 5 * time.Second},
+			},
+		},
+		"/events.json": {
+			QueryParams:    []string{"cursor", "limit"},
+			Description:    "Raw utilization rate or price quote events (cursor-paginated)",
+			Example:        "/ri_apow_supply_0/events.json?limit=10",
+			Handler:        handleEvents,
+			IncludeRemoved: true,
+			Variants: []*RouteConfig{
+				{DBPrefix: "ri_", SQL: rateEventsSQL, ResultScanner: scanRateEvents,
+					Response: EventPage{Events: []RateEvent{}}},
+				{DBPrefix: "rt_", SQL: quoteEventsSQL, ResultScanner: scanQuoteEvents,
+					Response: EventPage{Events: []QuoteEvent{}}},
+			},
+		},
+		"/coverage.json": {
+			Description: "Block ranges, suspicious gaps, duplicate events and empty days",
+			Example:     "/ri_apow_supply_0/coverage.json",
+			Handler:     handleCoverage,
+			Cost:        10, // scans all logs (unless cached)
+			Variants: []*RouteConfig{
+				{DBPrefix: "ri_", Response: Coverage{}},
+				{DBPrefix: "rt_", Response: Coverage{}},
+			},
 		},
 	}
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -83,3 +84,81 @@ func TestRouteConfigDailyOHLC(t *testing.T) {
 		t.Errorf("unexpected Description: %s", config.Description)
 	}
 }
+
+func TestRouteConfigIntervalOHLC(t *testing.T) {
+	config := endpointRoutes["/ohlc.json"]
+	if config == nil {
+		t.Fatal("expected route /ohlc.json to exist in endpointRoutes")
+	}
+
+	if config.DBPrefix != "rt_" {
+		t.Errorf("expected DBPrefix rt_, got %s", config.DBPrefix)
+	}
+
+	if config.SQL != intervalOHLCSQL {
+		t.Error("expected SQL to match intervalOHLCSQL")
+	}
+
+	expectedParams := []string{"lhs", "rhs", "interval"}
+	if !reflect.DeepEqual(config.QueryParams, expectedParams) {
+		t.Errorf("expected QueryParams %v, got %v", expectedParams, config.QueryParams)
+	}
+
+	for _, param := range config.QueryParams {
+		if _, exists := queryParams[param]; !exists {
+			t.Errorf("expected query parameter %s to be configured", param)
+		}
+	}
+}
+
+func TestRouteConfigEvents(t *testing.T) {
+	config := endpointRoutes["/events.json"]
+	if config == nil {
+		t.Fatal("expected route /events.json to exist in endpointRoutes")
+	}
+
+	if config.Handler == nil {
+		t.Error("expected custom Handler for events route")
+	}
+
+	prefixes := make([]string, 0, len(config.Variants))
+	for _, variant := range config.Variants {
+		prefixes = append(prefixes, variant.DBPrefix)
+		if variant.SQL == "" || variant.ResultScanner == nil {
+			t.Errorf("variant %s: SQL or ResultScanner is empty", variant.DBPrefix)
+		}
+	}
+	if !reflect.DeepEqual(prefixes, []string{"ri_", "rt_"}) {
+		t.Errorf("expected variants [ri_ rt_], got %v", prefixes)
+	}
+}
+
+func TestRouteConfigLatest(t *testing.T) {
+	config := endpointRoutes["/latest.json"]
+	if config == nil {
+		t.Fatal("expected route /latest.json to exist in endpointRoutes")
+	}
+
+	if config.CacheMaxAge <= 0 || config.CacheMaxAge >= 3600 {
+		t.Errorf("expected short CacheMaxAge, got %d", config.CacheMaxAge)
+	}
+	if len(config.Variants) != 2 {
+		t.Errorf("expected ri_ and rt_ variants, got %d", len(config.Variants))
+	}
+}
+
+func TestRouteConfigStream(t *testing.T) {
+	config := endpointRoutes["/stream"]
+	if config == nil {
+		t.Fatal("expected route /stream to exist in endpointRoutes")
+	}
+
+	if config.Handler == nil {
+		t.Error("expected custom Handler for stream route")
+	}
+	for _, variant := range config.Variants {
+		if !strings.Contains(variant.SQL, "rowid > ?1") {
+			t.Errorf("variant %s: expected SQL to tail raw_logs by rowid", variant.DBPrefix)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Coverage reports which blocks and days the logs of a database cover
+type Coverage struct {
+	Logs       int64            `json:"logs"`    // including removed logs
+	Removed    int64            `json:"removed"` // logs of orphaned blocks
+	Cadence    int64            `json:"cadence"` // median blocks between events
+	Ranges     []BlockRange     `json:"ranges"`
+	Gaps       []BlockGap       `json:"gaps"`
+	Duplicates []DuplicateEvent `json:"duplicates"`
+	EmptyDays  []string         `json:"empty_days"`
+}
+
+// BlockRange is a range of blocks with logs and no suspicious gaps
+type BlockRange struct {
+	FromBlock int64   `json:"from_block"`
+	ToBlock   int64   `json:"to_block"`
+	From      *string `json:"from"`
+	To        *string `json:"to"`
+	Logs      int64   `json:"logs"`
+}
+
+// BlockGap is a suspicious gap between the blocks of two events
+type BlockGap struct {
+	FromBlock int64   `json:"from_block"` // last block before the gap
+	ToBlock   int64   `json:"to_block"`   // first block after the gap
+	From      *string `json:"from"`
+	To        *string `json:"to"`
+	Blocks    int64   `json:"blocks"`
+	Seconds   int64   `json:"seconds"`
+}
+
+// DuplicateEvent is an event stored under several IDs with differing payloads
+type DuplicateEvent struct {
+	TxHash   string   `json:"tx_hash"`
+	LogIndex int64    `json:"log_index"`
+	IDs      []string `json:"ids"`
+}
+
+// coverageQueries are the coverage statistics queries of a database kind
+type coverageQueries struct {
+	Counts     string // logs and removed logs
+	Blocks     string // blocks with logs, their time and number of logs
+	Days       string // days with logs
+	Duplicates string // events stored under several IDs with differing payloads
+}
+
+// coverageBlock is a block with logs
+type coverageBlock struct {
+	number int64
+	time   *string
+	logs   int64
+}
+
+// issues returns the number of gaps, duplicates and empty days
+func (c Coverage) issues() int {
+	return len(c.Gaps) + len(c.Duplicates) + len(c.EmptyDays)
+}
+
+// coverageOf queries the coverage of a database, reporting gaps wider than
+// gapFactor times the cadence (and longer than coverageMinGap) as suspicious
+func coverageOf(ctx context.Context, db *sql.DB, queries coverageQueries, gapFactor float64) (Coverage, error) {
+	coverage := Coverage{
+		Ranges:     []BlockRange{},
+		Gaps:       []BlockGap{},
+		Duplicates: []DuplicateEvent{},
+		EmptyDays:  []string{},
+	}
+	if err := db.QueryRowContext(ctx, queries.Counts).Scan(&coverage.Logs, &coverage.Removed); err != nil {
+		return coverage, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+
+	blocks, err := coverageBlocks(ctx, db, queries.Blocks)
+	if err != nil {
+		return coverage, err
+	}
+	coverage.Cadence = medianDistance(blocks)
+	coverage.Ranges, coverage.Gaps = splitBlocks(blocks, float64(coverage.Cadence)*gapFactor)
+
+	if coverage.EmptyDays, err = emptyDays(ctx, db, queries.Days); err != nil {
+		return coverage, err
+	}
+	if coverage.Duplicates, err = duplicateEvents(ctx, db, queries.Duplicates); err != nil {
+		return coverage, err
+	}
+	return coverage, nil
+}
+
+// coverageBlocks queries the blocks with logs in ascending order
+func coverageBlocks(ctx context.Context, db *sql.DB, query string) ([]coverageBlock, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	var blocks []coverageBlock
+	for rows.Next() {
+		var block coverageBlock
+		if err := rows.Scan(&block.number, &block.time, &block.logs); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// medianDistance returns the median number of blocks between consecutive
+// blocks with logs (zero for less than two blocks)
+func medianDistance(blocks []coverageBlock) int64 {
+	if len(blocks) < 2 {
+		return 0
+	}
+	distances := make([]int64, 0, len(blocks)-1)
+	for i := 1; i < len(blocks); i++ {
+		distances = append(distances, blocks[i].number-blocks[i-1].number)
+	}
+	slices.Sort(distances)
+	return distances[len(distances)/2]
+}
+
+// splitBlocks splits blocks into ranges at the gaps wider than maxBlocks
+// blocks and longer than coverageMinGap
+func splitBlocks(blocks []coverageBlock, maxBlocks float64) ([]BlockRange, []BlockGap) {
+	ranges, gaps := []BlockRange{}, []BlockGap{}
+	for i, block := range blocks {
+		if i > 0 {
+			prev := blocks[i-1]
+			gap := BlockGap{
+				FromBlock: prev.number,
+				ToBlock:   block.number,
+				From:      prev.time,
+				To:        block.time,
+				Blocks:    block.number - prev.number,
+			}
+			if prev.time != nil && block.time != nil {
+				gap.Seconds = int64(parseStamp(*block.time).Sub(parseStamp(*prev.time)).Seconds())
+			}
+			if float64(gap.Blocks) > maxBlocks && time.Duration(gap.Seconds)*time.Second >= coverageMinGap {
+				gaps = append(gaps, gap)
+			} else {
+				last := &ranges[len(ranges)-1]
+				last.ToBlock, last.To = block.number, block.time
+				last.Logs += block.logs
+				continue
+			}
+		}
+		ranges = append(ranges, BlockRange{
+			FromBlock: block.number,
+			ToBlock:   block.number,
+			From:      block.time,
+			To:        block.time,
+			Logs:      block.logs,
+		})
+	}
+	return ranges, gaps
+}
+
+// emptyDays returns the days without logs between the first and last day
+// with logs
+func emptyDays(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	empty := []string{}
+	var next time.Time
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		date, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day %q: %v", day, err)
+		}
+		for !next.IsZero() && next.Before(date) {
+			empty = append(empty, next.Format(time.DateOnly))
+			next = next.AddDate(0, 0, 1)
+		}
+		next = date.AddDate(0, 0, 1)
+	}
+	return empty, rows.Err()
+}
+
+// duplicateEvents queries the events stored under several IDs with
+// differing payloads
+func duplicateEvents(ctx context.Context, db *sql.DB, query string) ([]DuplicateEvent, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errQueryFailed, err)
+	}
+	defer rows.Close()
+
+	duplicates := []DuplicateEvent{}
+	for rows.Next() {
+		var duplicate DuplicateEvent
+		var ids string
+		if err := rows.Scan(&duplicate.TxHash, &duplicate.LogIndex, &ids); err != nil {
+			return nil, err
+		}
+		duplicate.IDs = strings.Fields(ids)
+		slices.Sort(duplicate.IDs)
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates, rows.Err()
+}
+
+// handleCoverage handles the coverage report of a database
+func handleCoverage(w http.ResponseWriter, r *http.Request, config *RouteConfig) {
+	// Extract database name from URL parameter
+	dbName := chi.URLParam(r, "dbName")
+
+	// Validate database name prefix (and select variant)
+	variant, err := routeVariant(dbName, config)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get database from pool (connection is reused, released when done)
+	db, dbFileName, release, err := getDatabase(dbName)
+	if err != nil {
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	// Answer conditional requests; coverage changes with every ingest
+	state, err := databaseState(dbName)
+	if err != nil {
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+	headers := newCacheHeaders(r, state, fmt.Sprintf("public, max-age=%d", currentMaxAge))
+	if checkConditional(w, r, headers) {
+		return
+	}
+
+	// Build the report (cached until the database changes)
+	results, err := queryCache.get(r.URL.Path, state, func() (results interface{}, err error) {
+		started := time.Now()
+		defer func() {
+			metrics.observeQuery(dbName, time.Since(started), nil, err)
+		}()
+
+		timeout := variant.Timeout
+		if timeout <= 0 {
+			timeout = queryTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		coverage, err := coverageOf(ctx, db, coverageSQL[variant.DBPrefix], coverageGapFactor)
+		if err != nil {
+			return nil, queryError(ctx, timeout, err)
+		}
+		return coverage, nil
+	})
+	if writeCanceled(w, r, dbName, err) {
+		return
+	}
+	if errors.Is(err, errQueryFailed) {
+		requestLog(r).Error("Query error", "db", dbName, "err", err)
+		writeError(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		requestLog(r).Error("Result scanning error", "db", dbName, "err", err)
+		writeError(w, "Data processing error", http.StatusInternalServerError)
+		return
+	}
+
+	// Write response (with the caching headers)
+	headers.write(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Database", dbFileName)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// testCoverageLines are rate logs every 2 blocks with a duplicate event, a
+// removed log and a gap of two days (one of them without logs)
+var testCoverageLines = []string{
+	testRateLine("r1", "500", "1000", 1763197200, 100, 0),
+	testRateLine("r2", "510", "1000", 1763197220, 102, 0),
+	testRateLine("r3", "520", "1000", 1763197240, 104, 0),
+	testRateLine("r3-dup", "525", "1000", 1763197240, 104, 0),
+	testRateLine("r4", "530", "1000", 1763197260, 106, 0),
+	strings.Replace(testRateLine("r5", "540", "1000", 1763197280, 108, 0), `"removed":false`, `"removed":true`, 1),
+	testRateLine("r6", "550", "1000", 1763370000, 1000, 0),
+}
+
+func TestCoverageOf(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_coverage", testCoverageLines...)
+	db, _, release, err := getDatabase("ri_test_coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	coverage, err := coverageOf(context.Background(), db, coverageSQL["ri_"], coverageGapFactor)
+	if err != nil {
+		t.Fatalf("coverageOf failed: %v", err)
+	}
+	if coverage.Logs != 7 || coverage.Removed != 1 || coverage.Cadence != 2 {
+		t.Errorf("expected 7 logs, 1 removed and cadence 2, got %+v", coverage)
+	}
+
+	ranges := []string{}
+	for _, r := range coverage.Ranges {
+		ranges = append(ranges, strings.Join([]string{*r.From, *r.To}, "/"))
+	}
+	wantRanges := []string{"2025-11-15 09:00:00/2025-11-15 09:01:00", "2025-11-17 09:00:00/2025-11-17 09:00:00"}
+	if !reflect.DeepEqual(ranges, wantRanges) || coverage.Ranges[0].Logs != 5 || coverage.Ranges[1].FromBlock != 1000 {
+		t.Errorf("expected ranges %v, got %+v", wantRanges, coverage.Ranges)
+	}
+	if len(coverage.Gaps) != 1 || coverage.Gaps[0].Blocks != 894 || coverage.Gaps[0].Seconds != 172740 {
+		t.Errorf("expected gap of 894 blocks, got %+v", coverage.Gaps)
+	}
+	if len(coverage.Duplicates) != 1 || !reflect.DeepEqual(coverage.Duplicates[0].IDs, []string{"r3", "r3-dup"}) {
+		t.Errorf("expected duplicate r3, got %+v", coverage.Duplicates)
+	}
+	if !reflect.DeepEqual(coverage.EmptyDays, []string{"2025-11-16"}) {
+		t.Errorf("expected empty day 2025-11-16, got %v", coverage.EmptyDays)
+	}
+
+	// Wide gaps of a short duration are not suspicious
+	coverage, err = coverageOf(context.Background(), db, coverageSQL["ri_"], 1000)
+	if err != nil || len(coverage.Gaps) != 0 || len(coverage.Ranges) != 1 {
+		t.Errorf("expected no gaps with a large gap factor, got %+v (%v)", coverage.Gaps, err)
+	}
+}
+
+func TestHandleCoverage(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "rt_test_coverage",
+		testQuoteLine("q1", "900", "1100", 1763197200, 100, 0),
+		testQuoteLine("q2", "910", "1110", 1763197260, 101, 0),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/rt_test_coverage/coverage.json", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var coverage Coverage
+	if err := json.Unmarshal(rr.Body.Bytes(), &coverage); err != nil {
+		t.Fatalf("failed to parse JSON response: %v", err)
+	}
+	if coverage.Logs != 2 || len(coverage.Ranges) != 1 || coverage.Gaps == nil || coverage.EmptyDays == nil {
+		t.Errorf("unexpected coverage %+v", coverage)
+	}
+	if rr.Header().Get("ETag") == "" {
+		t.Error("expected ETag header")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/xx_test_coverage/coverage.json", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid prefix, got %d", rr.Code)
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_verify", testCoverageLines...)
+	createTestDatabase(t, tempDir, "ri_test_clean", testCoverageLines[:3]...)
+	createTestDatabase(t, tempDir, "test_unknown", testCoverageLines[:3]...)
+	issues := filepath.Join(tempDir, "ri_test_verify.db")
+	clean := filepath.Join(tempDir, "ri_test_clean.db")
+	unknown := filepath.Join(tempDir, "test_unknown.db")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"issues", []string{issues}, 1},
+		{"clean", []string{clean}, 0},
+		{"json", []string{"--json", clean}, 0},
+		{"kind", []string{"--kind=ri", unknown}, 0},
+		{"large gap factor", []string{"-G", "1000", issues}, 1}, // duplicate and empty day
+		{"unknown kind", []string{unknown}, 2},
+		{"missing database", []string{filepath.Join(tempDir, "ri_missing.db")}, 2},
+		{"missing argument", []string{}, 2},
+		{"invalid gap factor", []string{"--gap-factor=0", clean}, 2},
+		{"help", []string{"--help"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runVerify(tt.args, io.Discard); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+
+	var out strings.Builder
+	runVerify([]string{issues}, &out)
+	for _, want := range []string{"Suspicious gaps: 1", "106-1000", "ids r3, r3-dup", "2025-11-16"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -14,17 +14,32 @@ import (
 
 var (
 	// Database connection pool
-	dbPool = make(map[string]*sql.DB)
+	dbPool = make(map[string]*pooledDB)
 	dbMux  sync.RWMutex
 )
 
-// getDatabase gets or creates a database connection from the pool
-func getDatabase(dbName string) (*sql.DB, string, error) {
+// pooledDB is a pooled database connection with its in-flight users
+type pooledDB struct {
+	*sql.DB
+	inFlight sync.WaitGroup // users between getDatabase and release
+}
+
+// acquire registers a user of the pooled connection (with dbMux held, so
+// evictions cannot miss it) and returns its release function
+func (p *pooledDB) acquire() func() {
+	p.inFlight.Add(1)
+	return p.inFlight.Done
+}
+
+// getDatabase gets or creates a database connection from the pool; callers
+// must call release once done, so evictions do not close it under them
+func getDatabase(dbName string) (db *sql.DB, dbFileName string, release func(), err error) {
 	// Try to get existing connection from pool (read lock)
 	dbMux.RLock()
-	if db, exists := dbPool[dbName]; exists {
+	if pool, exists := dbPool[dbName]; exists {
+		release := pool.acquire()
 		dbMux.RUnlock()
-		return db, dbName + ".db", nil
+		return pool.DB, dbName + ".db", release, nil
 	}
 	dbMux.RUnlock()
 
@@ -33,8 +48,8 @@ func getDatabase(dbName string) (*sql.DB, string, error) {
 	defer dbMux.Unlock()
 
 	// Double-check in case another goroutine created it
-	if db, exists := dbPool[dbName]; exists {
-		return db, dbName + ".db", nil
+	if pool, exists := dbPool[dbName]; exists {
+		return pool.DB, dbName + ".db", pool.acquire(), nil
 	}
 
 	// Create new connection
@@ -42,12 +57,12 @@ func getDatabase(dbName string) (*sql.DB, string, error) {
 
 	// Check if file exists
 	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		return nil, "", fmt.Errorf("database not found: %s", dbName)
+		return nil, "", nil, fmt.Errorf("database not found: %s", dbName)
 	}
 
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", dbFile))
+	db, err = sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", dbFile))
 	if err != nil {
-		return nil, "", err
+		return nil, "", nil, err
 	}
 
 	// Configure connection pool settings
@@ -59,14 +74,85 @@ func getDatabase(dbName string) (*sql.DB, string, error) {
 	// Test connection
 	if err := db.Ping(); err != nil {
 		db.Close()
-		return nil, "", err
+		return nil, "", nil, err
+	}
+
+	// Refuse databases without typed columns (the endpoint queries need them)
+	if err := checkSchema(db); err != nil {
+		db.Close()
+		return nil, "", nil, fmt.Errorf("database %s: %v", dbName, err)
 	}
 
 	// Store in pool
-	dbPool[dbName] = db
-	log.Printf("Created connection pool for database: %s", dbName)
+	pool := &pooledDB{DB: db}
+	dbPool[dbName] = pool
+	slog.Info("Created connection pool", "db", dbName)
+
+	return db, filepath.Base(dbFile), pool.acquire(), nil
+}
+
+// evictDatabase evicts the pooled connection of a database (if any), and
+// closes it once its in-flight users have released it
+func evictDatabase(dbName string) {
+	dbMux.Lock()
+	pool, exists := dbPool[dbName]
+	delete(dbPool, dbName)
+	dbMux.Unlock()
+
+	if !exists {
+		return
+	}
+	go func() {
+		pool.inFlight.Wait()
+		if err := pool.Close(); err != nil {
+			slog.Error("Database close error", "db", dbName, "err", err)
+		}
+	}()
+}
 
-	return db, filepath.Base(dbFile), nil
+// closeDatabases closes and evicts all pooled database connections (on
+// shutdown, once in-flight requests are drained or forcibly closed)
+func closeDatabases() {
+	dbMux.Lock()
+	defer dbMux.Unlock()
+
+	for dbName, db := range dbPool {
+		if err := db.Close(); err != nil {
+			slog.Error("Database close error", "db", dbName, "err", err)
+		}
+		delete(dbPool, dbName)
+	}
+}
+
+// dbState is the modification state of a database file and its WAL file
+type dbState struct {
+	DBModTime  int64 // database file modification time (Unix nanoseconds)
+	DBSize     int64 // database file size in bytes
+	WALModTime int64 // WAL file modification time (zero if absent)
+	WALSize    int64 // WAL file size in bytes (zero if absent)
+}
+
+// modTime returns the latest modification time of the database or WAL file
+func (s dbState) modTime() time.Time {
+	return time.Unix(0, max(s.DBModTime, s.WALModTime))
+}
+
+// databaseState stats the database file (and WAL file, if any) of dbName
+func databaseState(dbName string) (dbState, error) {
+	dbFile := filepath.Join(dbPath, dbName+".db")
+
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		return dbState{}, err
+	}
+	state := dbState{DBModTime: info.ModTime().UnixNano(), DBSize: info.Size()}
+
+	if wal, err := os.Stat(dbFile + "-wal"); err == nil {
+		state.WALModTime = wal.ModTime().UnixNano()
+		state.WALSize = wal.Size()
+	}
+
+	return state, nil
 }
 
 // validateDatabases checks all databases are accessible at startup
@@ -100,22 +186,30 @@ func validateDatabases() error {
 		// Try to open and ping the database
 		db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", realPath))
 		if err != nil {
-			log.Printf("[!!] %s", realPath)
-			log.Printf("%s", err)
+			slog.Error("Database check failed", "path", realPath, "err", err)
 			hasErrors = true
 			continue
 		}
 
 		err = db.Ping()
-		db.Close()
 		if err != nil {
-			log.Printf("[!!] %s", realPath)
-			log.Printf("%s", err)
+			db.Close()
+			slog.Error("Database check failed", "path", realPath, "err", err)
 			hasErrors = true
 			continue
 		}
 
-		log.Printf("[ok] %s", realPath)
+		// Outdated databases are not fatal, since their writers migrate them
+		// (ingest units when restarted); until then their endpoints answer
+		// 503, and they are served once migrated
+		err = checkSchema(db)
+		db.Close()
+		if err != nil {
+			slog.Warn("Database not served until migrated by its writer", "path", realPath, "err", err)
+			continue
+		}
+
+		slog.Info("Database check passed", "path", realPath)
 	}
 
 	if hasErrors {
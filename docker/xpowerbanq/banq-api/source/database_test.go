@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -327,3 +328,73 @@ func TestDatabaseReadOnly(t *testing.T) {
 		t.Errorf("expected count=1, got %d", count)
 	}
 }
+
+func TestDatabaseState(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_state")
+
+	state, err := databaseState("ri_test_state")
+	if err != nil {
+		t.Fatalf("databaseState failed: %v", err)
+	}
+	if state.DBSize == 0 || state.DBModTime == 0 {
+		t.Errorf("expected database file state, got %+v", state)
+	}
+	if state.WALSize != 0 || state.WALModTime != 0 {
+		t.Errorf("expected no WAL file state, got %+v", state)
+	}
+
+	// WAL files count towards the state
+	walFile := filepath.Join(tempDir, "ri_test_state.db-wal")
+	if err := os.WriteFile(walFile, []byte("wal"), 0644); err != nil {
+		t.Fatalf("failed to create WAL file: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(walFile, future, future)
+
+	walState, err := databaseState("ri_test_state")
+	if err != nil {
+		t.Fatalf("databaseState failed: %v", err)
+	}
+	if walState == state || walState.WALSize != 3 {
+		t.Errorf("expected WAL file state, got %+v", walState)
+	}
+	if !walState.modTime().Equal(time.Unix(0, future.UnixNano())) {
+		t.Errorf("expected modTime of WAL file, got %v", walState.modTime())
+	}
+
+	if _, err := databaseState("ri_nonexistent"); err == nil {
+		t.Errorf("expected error for missing database")
+	}
+}
+
+func TestEvictDatabaseInFlight(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_evict",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	)
+	db, _, release, err := getDatabase("ri_test_evict")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Evicted pools are gone from the map, but stay open for in-flight queries
+	evictDatabase("ri_test_evict")
+	if pooled("ri_test_evict") {
+		t.Error("expected pool to be evicted")
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM raw_logs").Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected in-flight query to succeed, got %d (%v)", count, err)
+	}
+
+	// and are closed once released
+	release()
+	deadline := time.Now().Add(time.Second)
+	for db.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected evicted pool to be closed after release")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// statusClientClosedRequest is the (nginx) status of requests whose client
+// went away before the response
+const statusClientClosedRequest = 499
+
 // validateDate validates ISO date format (YYYY-MM-DD)
 func validateDate(date string) bool {
 	return dateRegex.MatchString(date)
 }
 
-// writeError writes JSON error response
+// writeError writes JSON error response (never cached)
 func writeError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 }
@@ -27,8 +38,9 @@ func handleEndpoint(w http.ResponseWriter, r *http.Request, config *RouteConfig)
 	// Extract database name from URL parameter
 	dbName := chi.URLParam(r, "dbName")
 
-	// Validate database name prefix
-	if err := dbPrefixed(dbName, config.DBPrefix); err != nil {
+	// Validate database name prefix (and select variant)
+	variant, err := routeVariant(dbName, config)
+	if err != nil {
 		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -36,7 +48,7 @@ func handleEndpoint(w http.ResponseWriter, r *http.Request, config *RouteConfig)
 	// Parse query parameters
 	var queryArgs []interface{}
 	for _, param := range config.QueryParams {
-		value, err := dateFrom(r, param)
+		value, err := paramFrom(r, param)
 		if err != nil {
 			writeError(w, err.Error(), http.StatusBadRequest)
 			return
@@ -44,40 +56,301 @@ func handleEndpoint(w http.ResponseWriter, r *http.Request, config *RouteConfig)
 		queryArgs = append(queryArgs, value)
 	}
 
-	// Add maxRows limit to query arguments
-	queryArgs = append(queryArgs, maxRows)
+	// Parse response envelope and removed logs options
+	envelope, err := envelopeFrom(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeRemoved, err := includeRemovedFrom(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Add row limit to query arguments (plus one row to detect truncation),
+	// followed by the removed logs option
+	limit := rowLimit(r)
+	if envelope {
+		limit++
+	}
+	queryArgs = append(queryArgs, limit, includeRemoved)
 
-	// Get database from pool (connection is reused, not closed)
-	db, dbFileName, err := getDatabase(dbName)
+	// Get database from pool (connection is reused, released when done)
+	db, dbFileName, release, err := getDatabase(dbName)
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
 		writeError(w, "Database not available", http.StatusServiceUnavailable)
 		return
 	}
+	defer release()
 
-	// Execute query
-	rows, err := db.Query(config.SQL, queryArgs...)
+	// Answer conditional requests from the database state
+	state, err := databaseState(dbName)
 	if err != nil {
-		log.Printf("Query error: %v", err)
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+	headers := newCacheHeaders(r, state, cacheControl(r, config))
+	if checkConditional(w, r, headers) {
+		return
+	}
+
+	// Execute query and scan results (cached by route, database and parameters)
+	cacheKey := fmt.Sprint(r.URL.Path, queryArgs)
+	results, err := queryCache.get(cacheKey, state, func() (interface{}, error) {
+		return runQuery(r.Context(), db, dbName, variant, queryArgs)
+	})
+	if errors.Is(err, errNoData) {
+		writeError(w, "No data available", http.StatusNotFound)
+		return
+	}
+	if writeCanceled(w, r, dbName, err) {
+		return
+	}
+	if errors.Is(err, errQueryFailed) {
+		requestLog(r).Error("Query error", "db", dbName, "err", err)
 		writeError(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
+	if err != nil {
+		requestLog(r).Error("Result scanning error", "db", dbName, "err", err)
+		writeError(w, "Data processing error", http.StatusInternalServerError)
+		return
+	}
+
+	// Empty ranges may still be filled (by backfills or reorgs)
+	if rows := reflect.ValueOf(results); rows.Kind() == reflect.Slice && rows.Len() == 0 {
+		headers = headers.mutable()
+	}
+
+	// Wrap results in envelope if requested (single records are never truncated)
+	if envelope && reflect.ValueOf(results).Kind() == reflect.Slice {
+		results = envelopeOf(r, results)
+	}
+
+	// Write response (with caching headers for Cloudflare)
+	headers.write(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Database", dbFileName)
+	// Report how old a single timestamped record is (not Age, which is the
+	// time a response spent in caches)
+	if record, ok := results.(stamped); ok {
+		age := int64(time.Since(record.stamp()).Seconds())
+		w.Header().Set("X-Data-Age", strconv.FormatInt(max(age, 0), 10))
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// runQuery executes the query of a route on a database and scans its results
+// (recording query duration and scanned rows in metrics); the query is
+// interrupted when the route's timeout expires or the context is canceled
+func runQuery(ctx context.Context, db *sql.DB, dbName string, config *RouteConfig, queryArgs []interface{}) (results interface{}, err error) {
+	started := time.Now()
+	defer func() {
+		metrics.observeQuery(dbName, time.Since(started), results, err)
+	}()
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = queryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if config.NamedArgs {
+		queryArgs = namedArgs(config.QueryParams, queryArgs)
+	}
+	rows, err := db.QueryContext(ctx, config.SQL, queryArgs...)
+	if err != nil {
+		return nil, queryError(ctx, timeout, fmt.Errorf("%w: %v", errQueryFailed, err))
+	}
 	defer rows.Close()
 
-	// Scan results using configured scanner
-	results, err := config.ResultScanner(rows)
+	results, err = config.ResultScanner(rows)
 	if err != nil {
-		log.Printf("Result scanning error: %v", err)
+		return nil, queryError(ctx, timeout, err)
+	}
+	return results, nil
+}
+
+// queryError attributes the error of an interrupted query to its timeout or
+// to the cancellation of its context
+func queryError(ctx context.Context, timeout time.Duration, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w after %s", errQueryTimeout, timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %v", errQueryCanceled, context.Cause(ctx))
+	default:
+		return err
+	}
+}
+
+// writeCanceled answers requests whose query timed out (504) or was canceled
+// by the client going away, and reports whether it did
+func writeCanceled(w http.ResponseWriter, r *http.Request, dbName string, err error) bool {
+	switch {
+	case errors.Is(err, errQueryTimeout):
+		requestLog(r).Warn("Query timed out", "db", dbName, "err", err)
+		writeError(w, "Query timed out", http.StatusGatewayTimeout)
+		return true
+	case errors.Is(err, errQueryCanceled):
+		// Nobody is listening anymore, but the status shows up in the logs
+		requestLog(r).Info("Query canceled", "db", dbName, "err", err)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(statusClientClosedRequest)
+		return true
+	default:
+		return false
+	}
+}
+
+// namedArgs names query arguments by their parameters, which are followed by
+// the row limit and the removed logs option
+func namedArgs(params []string, queryArgs []interface{}) []interface{} {
+	names := append(append([]string{}, params...), "limit", "include_removed")
+	named := make([]interface{}, len(queryArgs))
+	for i, arg := range queryArgs {
+		named[i] = sql.Named(names[i], arg)
+	}
+	return named
+}
+
+// envelopeOf wraps results (queried with one extra row) in an Envelope,
+// truncating them to the row limit
+func envelopeOf(r *http.Request, results interface{}) Envelope {
+	limit := rowLimit(r)
+	rows := reflect.ValueOf(results)
+	envelope := Envelope{
+		LHS:     r.URL.Query().Get("lhs"),
+		RHS:     r.URL.Query().Get("rhs"),
+		Count:   rows.Len(),
+		Results: results,
+	}
+
+	if rows.Len() > limit {
+		// The first omitted row's day is where the next page starts
+		if next, ok := rows.Index(limit).Interface().(dated); ok {
+			if day := next.date(); day != "" {
+				envelope.NextLHS = &day
+			}
+		}
+		envelope.Results = rows.Slice(0, limit).Interface()
+		envelope.Count = limit
+		envelope.Truncated = true
+	}
+
+	return envelope
+}
+
+// handleEvents handles cursor-paginated raw event endpoints using RouteConfig
+func handleEvents(w http.ResponseWriter, r *http.Request, config *RouteConfig) {
+	// Extract database name from URL parameter
+	dbName := chi.URLParam(r, "dbName")
+
+	// Validate database name prefix (and select variant)
+	variant, err := routeVariant(dbName, config)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse cursor and page size
+	cursor, err := paramFrom(r, "cursor")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := paramFrom(r, "limit")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeRemoved, err := includeRemovedFrom(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get database from pool (connection is reused, released when done)
+	db, dbFileName, release, err := getDatabase(dbName)
+	if err != nil {
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	// Answer conditional requests; pages may still grow, so cache briefly
+	state, err := databaseState(dbName)
+	if err != nil {
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+	headers := newCacheHeaders(r, state, fmt.Sprintf("public, max-age=%d", currentMaxAge))
+	if checkConditional(w, r, headers) {
+		return
+	}
+
+	// Execute query and scan results (plus one row to detect a next page)
+	after := cursor.(eventCursor)
+	results, err := runQuery(r.Context(), db, dbName, variant, []interface{}{after.Block, after.Index, limit.(int) + 1, includeRemoved})
+	if writeCanceled(w, r, dbName, err) {
+		return
+	}
+	if errors.Is(err, errQueryFailed) {
+		requestLog(r).Error("Query error", "db", dbName, "err", err)
+		writeError(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		requestLog(r).Error("Result scanning error", "db", dbName, "err", err)
 		writeError(w, "Data processing error", http.StatusInternalServerError)
 		return
 	}
 
-	// Write response with caching headers for Cloudflare
+	// Link to the next page if there are more events (keeping a query API
+	// key, so that the next page is served with the same tier)
+	events, last, more := truncateEvents(results, limit.(int))
+	page := EventPage{Events: events}
+	if more {
+		query := url.Values{}
+		query.Set("cursor", eventCursor{Block: last.BlockNumber, Index: last.LogIndex}.String())
+		query.Set("limit", strconv.Itoa(limit.(int)))
+		if includeRemoved {
+			query.Set("include_removed", "1")
+		}
+		if key := r.URL.Query().Get("api_key"); key != "" {
+			query.Set("api_key", key)
+		}
+		next := r.URL.Path + "?" + query.Encode()
+		page.Next = &next
+	}
+
+	// Write response (with the caching headers)
+	headers.write(w)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Database", dbFileName)
-	// Cache daily aggregated historical data for 1 hour
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-	json.NewEncoder(w).Encode(results)
+	json.NewEncoder(w).Encode(page)
+}
+
+// truncateEvents returns the first limit events of a page, the last of
+// them, and whether there were more
+func truncateEvents(results interface{}, limit int) (interface{}, EventLog, bool) {
+	switch events := results.(type) {
+	case []RateEvent:
+		if len(events) > limit {
+			return events[:limit], events[limit-1].EventLog, true
+		}
+	case []QuoteEvent:
+		if len(events) > limit {
+			return events[:limit], events[limit-1].EventLog, true
+		}
+	}
+	return results, EventLog{}, false
 }
 
 // handleRobots serves robots.txt
@@ -97,6 +370,21 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleDatabases serves the catalog of available databases
+func handleDatabases(w http.ResponseWriter, r *http.Request) {
+	infos, err := listDatabases()
+	if err != nil {
+		requestLog(r).Error("Catalog error", "err", err)
+		writeError(w, "Catalog not available", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Catalog changes as databases grow, so cache briefly
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	json.NewEncoder(w).Encode(infos)
+}
+
 // handleRoot serves API information
 func handleRoot(w http.ResponseWriter, r *http.Request) {
 	// Dynamically build endpoints documentation from route registry
@@ -113,7 +401,7 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 				if i > 0 {
 					params += "&"
 				}
-				params += param + "=YYYY-MM-DD"
+				params += param + "=" + queryParams[param].Format
 			}
 		}
 
@@ -126,15 +414,22 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=3600")
+	// Databases come and go, so cache briefly
+	w.Header().Set("Cache-Control", "public, max-age=60")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"title":       "XPower Banq Database API",
-		"description": "Read-only API for XPower Banq utilization rates and price quotes",
+		"title":       apiTitle,
+		"description": apiDescription,
 		"license":     "GPL-3.0",
 		"license_url": "https://www.gnu.org/licenses/gpl-3.0.en.html",
 		"source":      "xpower-banq-cli",
 		"source_url":  "https://github.com/blackhan-software/xpower-banq-cli.git",
+		"openapi_url": "/openapi.json",
 		"endpoints":   endpoints,
+		"databases":   knownDatabases(),
+		"options": map[string]string{
+			"envelope":        "Set envelope=1 to wrap results as {lhs, rhs, count, truncated, next_lhs, results}",
+			"include_removed": "Set include_removed=1 to include logs removed by chain reorgs (for debugging)",
+		},
 	})
 }
 
@@ -147,8 +442,15 @@ func registerAPIRoutes(r chi.Router) {
 		// Register route with URL parameter pattern
 		// e.g., "/{dbName}/daily_average.json"
 		pattern := "/{dbName}" + suffix
-		r.Get(pattern, func(w http.ResponseWriter, r *http.Request) {
-			handleEndpoint(w, r, routeConfig)
+		handler := handleEndpoint
+		if routeConfig.Handler != nil {
+			handler = routeConfig.Handler
+		}
+		r.With(
+			apiAccessMiddleware(routeName(suffix)),
+			rateLimitMiddleware(routeCost(routeConfig)),
+		).Get(pattern, func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, routeConfig)
 		})
 	}
 }
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	_ "github.com/mattn/go-sqlite3"
@@ -440,3 +445,605 @@ func TestHandleDailyOHLCIntegration(t *testing.T) {
 	// For this test, we'll use the helper function
 	t.Skip("Skipping integration test - requires database path injection")
 }
+
+// Integration test with real database for interval OHLC
+func TestHandleIntervalOHLCIntegration(t *testing.T) {
+	createTestDatabase(t, t.TempDir(), "rt_test_interval",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 100, 0), // Sat 09:00
+		testQuoteLine("q2", "120000000000000000000", "130000000000000000000", 1763209800, 101, 0), // Sat 12:30
+		testQuoteLine("q3", "90000000000000000000", "100000000000000000000", 1763211600, 102, 0),  // Sat 13:00
+		testQuoteLine("q4", "200000000000000000000", "200000000000000000000", 1763341200, 103, 0), // Mon 01:00
+	)
+
+	tests := []struct {
+		interval string
+		expected []IntervalOHLC
+	}{
+		{"4h", []IntervalOHLC{
+			{High: 105, Low: 105, Time: "2025-11-15 08:00:00", N: 1},
+			{High: 125, Low: 95, Time: "2025-11-15 12:00:00", N: 2},
+			{High: 200, Low: 200, Time: "2025-11-17 00:00:00", N: 1},
+		}},
+		{"1d", []IntervalOHLC{
+			{High: 125, Low: 95, Time: "2025-11-15 00:00:00", N: 3},
+			{High: 200, Low: 200, Time: "2025-11-17 00:00:00", N: 1},
+		}},
+		{"1w", []IntervalOHLC{
+			{High: 125, Low: 95, Time: "2025-11-10 00:00:00", N: 3},
+			{High: 200, Low: 200, Time: "2025-11-17 00:00:00", N: 1},
+		}},
+		{"1M", []IntervalOHLC{
+			{High: 200, Low: 95, Time: "2025-11-01 00:00:00", N: 4},
+		}},
+	}
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet,
+				"/rt_test_interval/ohlc.json?lhs=2025-11-01&rhs=2025-11-30&interval="+tt.interval, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			var results []IntervalOHLC
+			if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+				t.Fatalf("failed to parse JSON response: %v", err)
+			}
+			if len(results) != len(tt.expected) {
+				t.Fatalf("expected %d candles, got %d: %+v", len(tt.expected), len(results), results)
+			}
+			for i, expected := range tt.expected {
+				got := results[i]
+				if got.Time != expected.Time || got.N != expected.N ||
+					got.High != expected.High || got.Low != expected.Low {
+					t.Errorf("candle %d: expected %+v, got %+v", i, expected, got)
+				}
+				if got.Open == nil || got.Close == nil {
+					t.Errorf("candle %d: expected open and close to be set", i)
+				}
+			}
+		})
+	}
+
+	t.Run("open and close", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet,
+			"/rt_test_interval/ohlc.json?lhs=2025-11-15&rhs=2025-11-15&interval=1d", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var results []IntervalOHLC
+		if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+			t.Fatalf("failed to parse JSON response: %v", err)
+		}
+		if len(results) != 1 || *results[0].Open != 105 || *results[0].Close != 95 {
+			t.Errorf("expected open 105 and close 95, got %+v", results)
+		}
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet,
+			"/rt_test_interval/ohlc.json?lhs=2025-11-15&rhs=2025-11-15&interval=5m", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
+
+// Integration test with real databases for cursor-paginated events
+func TestHandleEventsIntegration(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_events",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "600000000000000000", "1000000100000000000000000000", 1763197210, 100, 1),
+		testRateLine("r3", "700000000000000000", "1000000200000000000000000000", 1763197220, 101, 0),
+	)
+	createTestDatabase(t, tempDir, "rt_test_events",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 200, 0),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var page map[string]json.RawMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to parse JSON response: %v", err)
+		}
+		return rr, page
+	}
+
+	// First page of two rate events
+	rr, page := get("/ri_test_events/events.json?limit=2")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var events []RateEvent
+	json.Unmarshal(page["events"], &events)
+	if len(events) != 2 || events[0].ID != "r1" || events[1].ID != "r2" {
+		t.Fatalf("unexpected first page: %+v", events)
+	}
+	if events[1].IndexRay != "1000000100000000000000000000" || events[1].Util != 0.6 {
+		t.Errorf("unexpected scaled values: %+v", events[1])
+	}
+	if events[1].Stamp != "2025-11-15 09:00:10" || events[1].BlockNumber != 100 || events[1].LogIndex != 1 {
+		t.Errorf("unexpected event log: %+v", events[1])
+	}
+	var next string
+	if err := json.Unmarshal(page["next"], &next); err != nil || next == "" {
+		t.Fatalf("expected next link, got %s", page["next"])
+	}
+
+	// Second (last) page
+	rr, page = get(next)
+	json.Unmarshal(page["events"], &events)
+	if len(events) != 1 || events[0].ID != "r3" {
+		t.Fatalf("unexpected second page: %+v", events)
+	}
+	if string(page["next"]) != "null" {
+		t.Errorf("expected no next link on last page, got %s", page["next"])
+	}
+
+	// Exactly full pages have no next link if there are no more events
+	_, page = get("/ri_test_events/events.json?limit=3")
+	json.Unmarshal(page["events"], &events)
+	if len(events) != 3 || string(page["next"]) != "null" {
+		t.Errorf("expected 3 events without next link, got %d and %s", len(events), page["next"])
+	}
+
+	// Quote events are served from rt_ databases
+	rr, page = get("/rt_test_events/events.json")
+	var quotes []QuoteEvent
+	json.Unmarshal(page["events"], &quotes)
+	if len(quotes) != 1 || quotes[0].Bid != 100 || quotes[0].AskWei != "110000000000000000000" {
+		t.Errorf("unexpected quote events: %+v", quotes)
+	}
+
+	// Invalid inputs
+	for _, path := range []string{
+		"/xx_test_events/events.json",
+		"/ri_test_events/events.json?cursor=invalid",
+		"/ri_test_events/events.json?limit=0",
+	} {
+		rr, _ = get(path)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// Integration test with real database for the truncation envelope
+func TestHandleEnvelopeIntegration(t *testing.T) {
+	createTestDatabase(t, t.TempDir(), "ri_test_envelope",
+		testRateLine("r1", "100000000000000000", "1000000000000000000000000000", 1763197200, 100, 0), // 2025-11-15
+		testRateLine("r2", "200000000000000000", "1000000000000000000000000000", 1763341200, 101, 0), // 2025-11-17
+		testRateLine("r3", "300000000000000000", "1000000000000000000000000000", 1764633600, 102, 0), // 2025-12-02
+	)
+
+	origMaxRows := maxRows
+	maxRows = 2
+	defer func() { maxRows = origMaxRows }()
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(query string) (*httptest.ResponseRecorder, Envelope, []DailyAverage) {
+		req := httptest.NewRequest(http.MethodGet, "/ri_test_envelope/daily_average.json?"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var envelope Envelope
+		var results []DailyAverage
+		envelope.Results = &results
+		json.Unmarshal(rr.Body.Bytes(), &envelope)
+		return rr, envelope, results
+	}
+
+	// Truncated page links to the next lhs
+	rr, envelope, results := get("lhs=2025-11-01&rhs=2025-12-31&envelope=1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if envelope.LHS != "2025-11-01" || envelope.RHS != "2025-12-31" {
+		t.Errorf("unexpected range: %s..%s", envelope.LHS, envelope.RHS)
+	}
+	if !envelope.Truncated || envelope.Count != 2 || len(results) != 2 {
+		t.Errorf("expected 2 truncated results, got %+v", envelope)
+	}
+	if envelope.NextLHS == nil || *envelope.NextLHS != "2025-12-02" {
+		t.Fatalf("expected next_lhs 2025-12-02, got %v", envelope.NextLHS)
+	}
+
+	// Continuation page is complete
+	_, envelope, results = get("lhs=" + *envelope.NextLHS + "&rhs=2025-12-31&envelope=1")
+	if envelope.Truncated || envelope.Count != 1 || envelope.NextLHS != nil || results[0].Day != "2025-12-02" {
+		t.Errorf("expected 1 complete result, got %+v %+v", envelope, results)
+	}
+
+	// Bare array stays the default
+	rr, _, _ = get("lhs=2025-11-01&rhs=2025-12-31")
+	var bare []DailyAverage
+	if err := json.Unmarshal(rr.Body.Bytes(), &bare); err != nil || len(bare) != 2 {
+		t.Errorf("expected bare array of 2 results, got %s", rr.Body.String())
+	}
+
+	// Invalid envelope option
+	rr, _, _ = get("lhs=2025-11-01&rhs=2025-12-31&envelope=yes")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// Integration test with real database for pages of intraday candles, which
+// continue at the time of the next candle (not at its day)
+func TestHandleIntervalOHLCEnvelopeIntegration(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ { // hourly from 2025-11-15 00:30 to 2025-11-16 05:30
+		lines = append(lines, testQuoteLine("q"+strconv.Itoa(i),
+			"100000000000000000000", "110000000000000000000", 1763166600+int64(i)*3600, 100+i, 0))
+	}
+	createTestDatabase(t, t.TempDir(), "rt_test_interval_envelope", lines...)
+
+	origMaxRows := maxRows
+	maxRows = 5 // fewer than the 24 candles of a day
+	defer func() { maxRows = origMaxRows }()
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	var times []string
+	lhs := "2025-11-15"
+	for page := 0; page < 10; page++ {
+		req := httptest.NewRequest(http.MethodGet, "/rt_test_interval_envelope/ohlc.json?"+url.Values{
+			"lhs": {lhs}, "rhs": {"2025-11-16"}, "interval": {"1h"}, "envelope": {"1"},
+		}.Encode(), nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var envelope Envelope
+		var results []IntervalOHLC
+		envelope.Results = &results
+		if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("failed to parse JSON response: %v", err)
+		}
+		for _, candle := range results {
+			times = append(times, candle.Time)
+		}
+		if !envelope.Truncated {
+			break
+		}
+		if envelope.NextLHS == nil || *envelope.NextLHS == lhs {
+			t.Fatalf("expected next_lhs to advance from %s, got %v", lhs, envelope.NextLHS)
+		}
+		lhs = *envelope.NextLHS
+	}
+
+	// Every candle once, in order
+	if len(times) != 30 {
+		t.Fatalf("expected 30 candles, got %d: %v", len(times), times)
+	}
+	for i, got := range times {
+		if expected := time.Unix(1763164800+int64(i)*3600, 0).UTC().Format("2006-01-02 15:04:05"); got != expected {
+			t.Errorf("candle %d: expected %s, got %s", i, expected, got)
+		}
+	}
+}
+
+// Integration test with real databases for the latest quote and rate
+func TestHandleLatestIntegration(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "rt_test_latest",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 100, 0),
+		testQuoteLine("q2", "120000000000000000000", "130000000000000000000", 1763209800, 101, 0),
+	)
+	createTestDatabase(t, tempDir, "ri_test_latest",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "250000000000000000", "2000000000000000000000000000", 1763197210, 100, 1),
+	)
+	createTestDatabase(t, tempDir, "ri_test_latest_empty")
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/rt_test_latest/latest.json")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var quote LatestQuote
+	json.Unmarshal(rr.Body.Bytes(), &quote)
+	expected := LatestQuote{Bid: 120, Ask: 130, Mid: 125, QuoteTime: "2025-11-15 12:30:00"}
+	if quote != expected {
+		t.Errorf("expected %+v, got %+v", expected, quote)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("expected short Cache-Control, got %q", cc)
+	}
+	if age, err := strconv.Atoi(rr.Header().Get("X-Data-Age")); err != nil ||
+		age < int(time.Since(parseStamp(expected.QuoteTime)).Seconds())-5 {
+		t.Errorf("expected X-Data-Age of latest quote, got %q", rr.Header().Get("X-Data-Age"))
+	}
+	if age := rr.Header().Get("Age"); age != "" {
+		t.Errorf("expected no Age header, got %q", age)
+	}
+
+	rr = get("/ri_test_latest/latest.json")
+	var rate LatestRate
+	json.Unmarshal(rr.Body.Bytes(), &rate)
+	if rate != (LatestRate{Util: 0.25, Index: 2, Stamp: "2025-11-15 09:00:10"}) {
+		t.Errorf("unexpected latest rate: %+v", rate)
+	}
+
+	if rr = get("/ri_test_latest_empty/latest.json"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for empty database, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr = get("/xx_test_latest/latest.json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for wrong prefix, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// Integration test with real database for daily annualized rates
+func TestHandleDailyRateIntegration(t *testing.T) {
+	createTestDatabase(t, t.TempDir(), "ri_test_rate",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763164800, 100, 0), // 2025-11-15 00:00
+		testRateLine("r2", "500000000000000000", "1000050000000000000000000000", 1763208000, 101, 0), // 2025-11-15 12:00
+		testRateLine("r3", "500000000000000000", "1000100000000000000000000000", 1763251200, 102, 0), // 2025-11-16 00:00
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/ri_test_rate/daily_rate.json?lhs=2025-11-15&rhs=2025-11-16", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var results []DailyRate
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("failed to parse JSON response: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 days, got %d: %+v", len(results), results)
+	}
+
+	day := results[0]
+	if day.Day != "2025-11-15" || day.N != 2 ||
+		day.FirstIndex != "1000000000000000000000000000" || day.LastIndex != "1000050000000000000000000000" {
+		t.Errorf("unexpected first day: %+v", day)
+	}
+	// 0.5 bp over half a day annualizes to 1 bp per day
+	assertRate(t, "apr", day.APR, ptr(0.0365))
+
+	// A single event per day has no growth span
+	if results[1].APR != nil || results[1].APY != nil {
+		t.Errorf("expected null rates for single event day, got %+v", results[1])
+	}
+}
+
+func TestHandleDatabases(t *testing.T) {
+	createTestDatabase(t, t.TempDir(), "rt_test_databases",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 200, 0),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/databases.json", nil)
+	rr := httptest.NewRecorder()
+	handleDatabases(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("expected short Cache-Control, got %q", cc)
+	}
+
+	var infos []DatabaseInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("failed to parse JSON response: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name != "rt_test_databases" || infos[0].Rows != 1 {
+		t.Errorf("unexpected catalog: %+v", infos)
+	}
+}
+
+// Integration test with real database for the query result cache
+func TestHandleCachedIntegration(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_cached",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	)
+
+	origCache := queryCache
+	queryCache = newResultCache(10, time.Minute)
+	defer func() { queryCache = origCache }()
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(query string) []DailyAverage {
+		req := httptest.NewRequest(http.MethodGet, "/ri_test_cached/daily_average.json?"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var results []DailyAverage
+		if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+			t.Fatalf("failed to parse JSON response: %v", err)
+		}
+		return results
+	}
+
+	get("lhs=2025-11-15&rhs=2025-12-15")
+	// Cache-busting parameters hit the same entry
+	get("lhs=2025-11-15&rhs=2025-12-15&_=12345")
+	if size, hits, misses := queryCache.stats(); size != 1 || hits != 1 || misses != 1 {
+		t.Errorf("expected 1 entry with 1 hit and 1 miss, got %d, %d, %d", size, hits, misses)
+	}
+
+	// Database changes invalidate the entry
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "ri_test_cached.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO raw_logs(id, json) VALUES('r2', ?)",
+		testRateLine("r2", "600000000000000000", "1000000000000000000000000000", 1763341200, 101, 0))
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to insert row: %v", err)
+	}
+
+	if results := get("lhs=2025-11-15&rhs=2025-12-15"); len(results) != 2 {
+		t.Errorf("expected fresh results after database change, got %+v", results)
+	}
+}
+
+func TestHandleQueryTimeout(t *testing.T) {
+	resetMetrics(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_slow")
+
+	// Endless recursive query, interrupted by the route's timeout
+	config := &RouteConfig{
+		DBPrefix:      "ri_",
+		SQL:           `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT x FROM c WHERE x < 0 LIMIT ?`,
+		ResultScanner: scanRows,
+		Timeout:       50 * time.Millisecond,
+	}
+	r := chi.NewRouter()
+	r.Get("/{dbName}/slow.json", func(w http.ResponseWriter, r *http.Request) {
+		handleEndpoint(w, r, config)
+	})
+
+	started := time.Now()
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ri_test_slow/slow.json?rhs=2025-01-01", nil))
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status 504, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// Timeouts of past ranges must not be cached (let alone as immutable)
+	if cc, etag := rr.Header().Get("Cache-Control"), rr.Header().Get("ETag"); cc != "no-store" || etag != "" {
+		t.Errorf("expected uncached timeout, got Cache-Control %q and ETag %q", cc, etag)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected query to be interrupted, took %v", elapsed)
+	}
+	var errResp ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil || errResp.Error != "Query timed out" {
+		t.Errorf("expected error response, got %s", rr.Body.String())
+	}
+
+	// Client disconnects cancel the query
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	config.Timeout = time.Minute
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ri_test_slow/slow.json", nil).WithContext(ctx))
+	if rr.Code != statusClientClosedRequest {
+		t.Fatalf("expected status 499, got %d", rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected uncached cancellation, got Cache-Control %q", cc)
+	}
+
+	text := scrapeMetrics(t)
+	assertMetric(t, text, `banq_query_cancellations_total{db="ri_test_slow",reason="client"} 1`)
+	assertMetric(t, text, `banq_query_cancellations_total{db="ri_test_slow",reason="timeout"} 1`)
+	assertMetric(t, text, `banq_query_errors_total{db="ri_test_slow"} 0`)
+}
+
+// Logs removed by chain reorgs are excluded unless include_removed=1
+func TestHandleRemovedIntegration(t *testing.T) {
+	removed := func(line string) string {
+		return strings.Replace(line, `"removed":false`, `"removed":true`, 1)
+	}
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_removed",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		removed(testRateLine("r2", "900000000000000000", "1000000100000000000000000000", 1763197210, 101, 0)),
+	)
+	createTestDatabase(t, tempDir, "rt_test_removed",
+		testQuoteLine("q1", "100000000000000000000", "110000000000000000000", 1763197200, 100, 0),
+		removed(testQuoteLine("q2", "300000000000000000000", "310000000000000000000", 1763197210, 101, 0)),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d: %s", http.StatusOK, path, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	var averages []DailyAverage
+	json.Unmarshal(get("/ri_test_removed/daily_average.json?lhs=2025-11-15&rhs=2025-11-15").Body.Bytes(), &averages)
+	if len(averages) != 1 || averages[0].N != 1 || averages[0].AvgUtil != 0.5 {
+		t.Errorf("expected removed rate to be excluded, got %+v", averages)
+	}
+	json.Unmarshal(get("/ri_test_removed/daily_average.json?lhs=2025-11-15&rhs=2025-11-15&include_removed=1").Body.Bytes(), &averages)
+	if len(averages) != 1 || averages[0].N != 2 {
+		t.Errorf("expected removed rate to be included, got %+v", averages)
+	}
+
+	var candles []DailyOHLC
+	json.Unmarshal(get("/rt_test_removed/daily_ohlc.json?lhs=2025-11-15&rhs=2025-11-15").Body.Bytes(), &candles)
+	if len(candles) != 1 || candles[0].N != 1 || candles[0].High != 105 {
+		t.Errorf("expected removed quote to be excluded, got %+v", candles)
+	}
+
+	var quote LatestQuote
+	json.Unmarshal(get("/rt_test_removed/latest.json").Body.Bytes(), &quote)
+	if quote.Mid != 105 {
+		t.Errorf("expected latest quote before the removed one, got %+v", quote)
+	}
+	json.Unmarshal(get("/rt_test_removed/latest.json?include_removed=1").Body.Bytes(), &quote)
+	if quote.Mid != 305 {
+		t.Errorf("expected removed latest quote to be included, got %+v", quote)
+	}
+
+	var page struct {
+		Events []RateEvent `json:"events"`
+		Next   *string     `json:"next"`
+	}
+	json.Unmarshal(get("/ri_test_removed/events.json").Body.Bytes(), &page)
+	if len(page.Events) != 1 || page.Events[0].ID != "r1" {
+		t.Errorf("expected removed event to be excluded, got %+v", page.Events)
+	}
+	json.Unmarshal(get("/ri_test_removed/events.json?limit=1&include_removed=1").Body.Bytes(), &page)
+	if page.Next == nil || !strings.Contains(*page.Next, "include_removed=1") {
+		t.Errorf("expected next link to keep include_removed, got %v", page.Next)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ri_test_removed/daily_average.json?lhs=2025-11-15&rhs=2025-11-15&include_removed=yes", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid include_removed, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ingestKind is the schema and validation of the JSON logs of a database
+// kind, as emitted by `banq reindex` (ri) and `banq retwap` (rt)
+type ingestKind struct {
+	view        string   // name of the view of the JSON logs
+	columnsSQL  string   // typed columns of raw_logs (besides the common ones)
+	schemaSQL   string   // view and indexes over the typed columns
+	timeField   string   // unix time of a log
+	valueFields []string // big integers of a log
+}
+
+const (
+	// ingestDSN opens a database for writing with the pragmas of the former
+	// banq-riw2db.sh and banq-rtw2db.sh scripts: wait for readers, do not
+	// block them (WAL) and reduce the fsync cost of append-only logs
+	ingestDSN = "file:%s?_busy_timeout=4096&_journal_mode=WAL&_synchronous=NORMAL"
+
+	// maxIngestLine is the maximum length of a JSON log line
+	maxIngestLine = 1 << 20
+)
+
+// tombstoneSQL marks the logs of block ?1 as removed unless they belong to
+// block hash ?2, i.e. once a chain reorg replaced their block
+const tombstoneSQL = `
+UPDATE raw_logs SET json = json_set(json, '$.log.removed', json('true'))
+WHERE log_block_number = ?1
+  AND log_block_hash != ?2
+  AND log_removed IS NOT 1`
+
+// ingestKinds are the database kinds by name (ri: rates indexed, rt: rates
+// tracked); their views have the columns of the former ingest scripts' views
+// (plus the unix time), so that custom queries keep working on migrated
+// databases
+var ingestKinds = map[string]ingestKind{
+	"ri": {
+		view: "riw_view",
+		columnsSQL: `
+  stamp_unix INTEGER GENERATED ALWAYS AS (CAST(REPLACE(json_extract(json,'$.stamp'),'n','') AS INTEGER)) STORED,
+  util_e18 REAL GENERATED ALWAYS AS ((REPLACE(json_extract(json,'$.util_wad'),'n','')+0.0)/1e18) STORED,
+  index_e27 REAL GENERATED ALWAYS AS ((REPLACE(json_extract(json,'$.index_ray'),'n','')+0.0)/1e27) STORED`,
+		schemaSQL: `
+CREATE VIEW IF NOT EXISTS riw_view AS
+  SELECT
+    id,
+    json_extract(json,'$.filter') AS filter,
+    json_extract(json,'$.mode') AS mode,
+    json_extract(json,'$.symbol') AS symbol,
+    json_extract(json,'$.token') AS token,
+
+    -- scales: keep raw strings (with 'n'), plus scaled numerics
+    index_e27,
+    json_extract(json,'$.index_ray') AS index_ray,
+    util_e18,
+    json_extract(json,'$.util_wad') AS util_wad,
+
+    -- timestamp (ISO 8601 and unix time)
+    datetime(stamp_unix,'unixepoch') AS stamp_iso,
+    json_extract(json,'$.stamp') AS stamp,
+    stamp_unix,
+
+    -- nested log.*
+    json_extract(json,'$.log._type') AS log_type,
+    json_extract(json,'$.log.address') AS log_address,
+    log_block_hash,
+    log_block_number,
+    json_extract(json,'$.log.data') AS log_data,
+    log_index,
+    log_removed,
+    json_extract(json,'$.log.topics') AS log_topics_json,
+    log_tx_hash,
+    CAST(json_extract(json,'$.log.transactionIndex') AS INTEGER) AS log_tx_index,
+
+    -- original payload
+    json
+  FROM raw_logs;
+CREATE INDEX IF NOT EXISTS idx_stamp_unix
+  ON raw_logs (stamp_unix, log_removed, util_e18);`,
+		timeField:   "stamp",
+		valueFields: []string{"index_ray", "util_wad"},
+	},
+	"rt": {
+		view: "rtw_view",
+		columnsSQL: `
+  quote_time_unix INTEGER GENERATED ALWAYS AS (CAST(REPLACE(json_extract(json,'$.quote_time'),'n','') AS INTEGER)) STORED,
+  quote_bid_e18 REAL GENERATED ALWAYS AS ((REPLACE(json_extract(json,'$.quote_bid'),'n','')+0.0)/1e18) STORED,
+  quote_ask_e18 REAL GENERATED ALWAYS AS ((REPLACE(json_extract(json,'$.quote_ask'),'n','')+0.0)/1e18) STORED`,
+		schemaSQL: `
+CREATE VIEW IF NOT EXISTS rtw_view AS
+  SELECT
+    id,
+    json_extract(json,'$.filter') AS filter,
+    json_extract(json,'$.source_symbol') AS source_symbol,
+    json_extract(json,'$.source_token') AS source_token,
+    json_extract(json,'$.target_symbol') AS target_symbol,
+    json_extract(json,'$.target_token') AS target_token,
+
+    -- scales: keep raw strings (with 'n'), plus scaled numerics
+    quote_bid_e18,
+    json_extract(json,'$.quote_bid') AS quote_bid,
+    quote_ask_e18,
+    json_extract(json,'$.quote_ask') AS quote_ask,
+
+    -- timestamp (ISO 8601 and unix time)
+    datetime(quote_time_unix,'unixepoch') AS quote_time_iso,
+    json_extract(json,'$.quote_time') AS quote_time,
+    quote_time_unix,
+
+    -- nested log.*
+    json_extract(json,'$.log._type') AS log_type,
+    json_extract(json,'$.log.address') AS log_address,
+    log_block_hash,
+    log_block_number,
+    json_extract(json,'$.log.data') AS log_data,
+    log_index,
+    log_removed,
+    json_extract(json,'$.log.topics') AS log_topics_json,
+    log_tx_hash,
+    CAST(json_extract(json,'$.log.transactionIndex') AS INTEGER) AS log_tx_index,
+
+    -- original payload
+    json
+  FROM raw_logs;
+CREATE INDEX IF NOT EXISTS idx_quote_time_unix
+  ON raw_logs (quote_time_unix, log_removed, quote_bid_e18, quote_ask_e18);`,
+		timeField:   "quote_time",
+		valueFields: []string{"quote_bid", "quote_ask"},
+	},
+}
+
+// ingestOptions bound the transactions of an ingest: a batch is committed
+// once it holds batchSize logs or is batchInterval old, whichever is first;
+// if toBlock is set, logs of blocks outside fromBlock..toBlock are ignored
+type ingestOptions struct {
+	batchSize     int
+	batchInterval time.Duration
+	fromBlock     int64
+	toBlock       int64
+}
+
+// ingestStats counts the lines of an ingest
+type ingestStats struct {
+	lines      int // non-empty lines read
+	inserted   int // logs inserted or replaced
+	skipped    int // invalid lines
+	outside    int // logs of blocks outside the range (if any)
+	tombstoned int // logs of orphaned blocks marked as removed
+	batches    int // committed transactions
+}
+
+// ingestLog is a validated JSON log with the block it was emitted in
+type ingestLog struct {
+	id        string
+	block     int64
+	blockHash string // empty if unknown
+	removed   bool
+}
+
+// ingester inserts logs into a database in batches (transactions)
+type ingester struct {
+	db        *sql.DB
+	insert    *sql.Stmt
+	tombstone *sql.Stmt
+	tx        *sql.Tx
+	txInsert  *sql.Stmt
+	txTomb    *sql.Stmt
+	pending   int
+	stats     ingestStats
+
+	// Last block checked for reorgs (logs arrive in block order)
+	block     int64
+	blockHash string
+}
+
+// openIngestDatabase opens (or creates) a database for writing and creates
+// (or migrates to) the typed schema of a kind
+func openIngestDatabase(path string, kind ingestKind) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf(ingestDSN, path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+	}
+	// A single writer connection keeps the pragmas and the batches together
+	db.SetMaxOpenConns(1)
+
+	if _, err := migrateDatabase(db, kind); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema of %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// validateLog checks that a line is a JSON log of a kind and returns its ID
+// and block
+func validateLog(line []byte, kind ingestKind) (ingestLog, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return ingestLog{}, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if fields == nil {
+		return ingestLog{}, errors.New("not a JSON object")
+	}
+
+	var entry ingestLog
+	if err := json.Unmarshal(fields["id"], &entry.id); err != nil || entry.id == "" {
+		return ingestLog{}, errors.New("missing id")
+	}
+	for _, field := range append([]string{kind.timeField}, kind.valueFields...) {
+		if !isBigInt(fields[field]) {
+			return ingestLog{}, fmt.Errorf("missing or invalid %s", field)
+		}
+	}
+	if raw, exists := fields["log"]; exists {
+		var logFields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &logFields); err != nil || logFields == nil {
+			return ingestLog{}, errors.New("invalid log")
+		}
+		// Logs without a (numeric) block are stored, but not checked for reorgs
+		if json.Unmarshal(logFields["blockNumber"], &entry.block) == nil {
+			json.Unmarshal(logFields["blockHash"], &entry.blockHash)
+		}
+		json.Unmarshal(logFields["removed"], &entry.removed)
+	}
+	return entry, nil
+}
+
+// isBigInt reports whether a JSON value is a non-negative integer, either as
+// number or as string with an optional BigInt suffix (e.g. "123n")
+func isBigInt(raw json.RawMessage) bool {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		value = string(raw)
+	}
+	value = strings.TrimSuffix(value, "n")
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// add inserts (or replaces) a log, beginning a new batch if needed
+func (in *ingester) add(entry ingestLog, line []byte) error {
+	if in.tx == nil {
+		tx, err := in.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin batch: %v", err)
+		}
+		in.tx, in.txInsert, in.txTomb = tx, tx.Stmt(in.insert), tx.Stmt(in.tombstone)
+	}
+	if err := in.checkReorg(entry); err != nil {
+		return err
+	}
+	if _, err := in.txInsert.Exec(entry.id, string(line)); err != nil {
+		return fmt.Errorf("failed to insert log %s: %v", entry.id, err)
+	}
+	in.pending++
+	return nil
+}
+
+// checkReorg tombstones the stored logs of a log's block number if they
+// belong to a different block hash, i.e. to a block orphaned by a chain
+// reorg; removed logs (which report their orphaned block) are not checked
+func (in *ingester) checkReorg(entry ingestLog) error {
+	if entry.blockHash == "" || entry.removed {
+		return nil
+	}
+	if entry.block == in.block && entry.blockHash == in.blockHash {
+		return nil
+	}
+	result, err := in.txTomb.Exec(entry.block, entry.blockHash)
+	if err != nil {
+		return fmt.Errorf("failed to tombstone logs of block %d: %v", entry.block, err)
+	}
+	in.block, in.blockHash = entry.block, entry.blockHash
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		in.stats.tombstoned += int(n)
+		slog.Warn("Chain reorg: tombstoned logs of orphaned block",
+			"block", entry.block, "block_hash", entry.blockHash, "logs", n)
+	}
+	return nil
+}
+
+// commit commits the current batch (if any)
+func (in *ingester) commit() error {
+	if in.tx == nil {
+		return nil
+	}
+	tx := in.tx
+	in.tx, in.txInsert, in.txTomb = nil, nil, nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %v", err)
+	}
+	in.stats.inserted += in.pending
+	in.stats.batches++
+	in.pending = 0
+	return nil
+}
+
+// ingest reads NDJSON logs from r into the database until EOF or until the
+// context is done, skipping (and logging) invalid lines; the pending batch is
+// committed in either case
+func ingest(ctx context.Context, db *sql.DB, kind ingestKind, r io.Reader, opts ingestOptions) (ingestStats, error) {
+	insert, err := db.PrepareContext(ctx, "INSERT OR REPLACE INTO raw_logs(id, json) VALUES(?, ?)")
+	if err != nil {
+		return ingestStats{}, fmt.Errorf("failed to prepare insert: %v", err)
+	}
+	defer insert.Close()
+	tombstone, err := db.PrepareContext(ctx, tombstoneSQL)
+	if err != nil {
+		return ingestStats{}, fmt.Errorf("failed to prepare tombstone: %v", err)
+	}
+	defer tombstone.Close()
+	in := &ingester{db: db, insert: insert, tombstone: tombstone}
+
+	// Read lines concurrently, so that batches can be committed by time
+	// while waiting for input (e.g. in watch mode)
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxIngestLine)
+		for scanner.Scan() {
+			select {
+			case lines <- bytes.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return in.stats, in.commit()
+
+		case <-flush:
+			flush = nil
+			if err := in.commit(); err != nil {
+				return in.stats, err
+			}
+
+		case line, ok := <-lines:
+			if !ok {
+				if err := in.commit(); err != nil {
+					return in.stats, err
+				}
+				if err := <-readErr; err != nil {
+					return in.stats, fmt.Errorf("failed to read logs: %v", err)
+				}
+				return in.stats, nil
+			}
+
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			in.stats.lines++
+			entry, err := validateLog(line, kind)
+			if err != nil {
+				in.stats.skipped++
+				slog.Warn("Skipping invalid log", "line", in.stats.lines, "err", err)
+				continue
+			}
+			if opts.toBlock > 0 && entry.block > 0 && (entry.block < opts.fromBlock || entry.block > opts.toBlock) {
+				in.stats.outside++
+				continue
+			}
+
+			if in.tx == nil {
+				flush = time.After(opts.batchInterval)
+			}
+			if err := in.add(entry, line); err != nil {
+				return in.stats, err
+			}
+			if in.pending >= opts.batchSize {
+				flush = nil
+				if err := in.commit(); err != nil {
+					return in.stats, err
+				}
+			}
+		}
+	}
+}
+
+// runIngest runs the ingest subcommand with its arguments (after "ingest"),
+// reading logs from stdin, and returns the exit code
+func runIngest(args []string, stdin io.Reader) int {
+	flags := flag.NewFlagSet("ingest", flag.ContinueOnError)
+
+	kindPtr := flags.String("k", "", "Kind of logs (ri or rt)")
+	flags.StringVar(kindPtr, "kind", "", "Kind of logs (ri or rt)")
+
+	batchSizePtr := flags.Int("B", 256, "Maximum number of logs per transaction")
+	flags.IntVar(batchSizePtr, "batch-size", 256, "Maximum number of logs per transaction")
+
+	batchIntervalPtr := flags.Duration("I", time.Second, "Maximum duration of a transaction")
+	flags.DurationVar(batchIntervalPtr, "batch-interval", time.Second, "Maximum duration of a transaction")
+
+	logFormatPtr := flags.String("L", logFormat, "Log output format (text or json)")
+	flags.StringVar(logFormatPtr, "log-format", logFormat, "Log output format (text or json)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ingest --kind=ri|rt [options] <db>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Ingest NDJSON logs from stdin into a database (created if missing)\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "  -k, --kind string\n")
+		fmt.Fprintf(os.Stderr, "        Kind of logs, ri (banq reindex) or rt (banq retwap)\n")
+		fmt.Fprintf(os.Stderr, "  -B, --batch-size int\n")
+		fmt.Fprintf(os.Stderr, "        Maximum number of logs per transaction (default: 256)\n")
+		fmt.Fprintf(os.Stderr, "  -I, --batch-interval duration\n")
+		fmt.Fprintf(os.Stderr, "        Maximum duration of a transaction (default: 1s)\n")
+		fmt.Fprintf(os.Stderr, "  -L, --log-format string\n")
+		fmt.Fprintf(os.Stderr, "        Log output format, text or json (default: %s)\n", logFormat)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	kind, exists := ingestKinds[*kindPtr]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "invalid kind: %q (expected ri or rt)\n", *kindPtr)
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *batchSizePtr < 1 || *batchIntervalPtr <= 0 {
+		fmt.Fprintln(os.Stderr, "batch size and interval must be positive")
+		return 2
+	}
+	if err := setupLogger(*logFormatPtr, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	path := flags.Arg(0)
+	db, err := openIngestDatabase(path, kind)
+	if err != nil {
+		slog.Error("Ingest failed", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	// Commit the pending batch on SIGTERM or SIGINT (e.g. by systemctl stop)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	slog.Info("Ingest starting", "db", path, "kind", *kindPtr)
+	stats, err := ingest(ctx, db, kind, stdin, ingestOptions{
+		batchSize:     *batchSizePtr,
+		batchInterval: *batchIntervalPtr,
+	})
+	attrs := []any{"db", path, "lines", stats.lines, "inserted", stats.inserted, "skipped", stats.skipped,
+		"tombstoned", stats.tombstoned, "batches", stats.batches}
+	if err != nil {
+		slog.Error("Ingest failed", append(attrs, "err", err)...)
+		return 1
+	}
+	slog.Info("Ingest finished", attrs...)
+	return 0
+}
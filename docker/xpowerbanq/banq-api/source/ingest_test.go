@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countLogs returns the number of logs in a database (on a new connection)
+func countLogs(t *testing.T, path string) int {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM raw_logs").Scan(&count); err != nil {
+		t.Fatalf("failed to count logs: %v", err)
+	}
+	return count
+}
+
+func TestValidateLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		line    string
+		wantID  string
+		wantErr string
+	}{
+		{"rate log", "ri", testRateLine("r1", "500", "1000", 1763197200, 100, 0), "r1", ""},
+		{"quote log", "rt", testQuoteLine("q1", "900", "1100", 1763197200, 100, 0), "q1", ""},
+		{"numeric values", "ri", `{"id":"r1","util_wad":500,"index_ray":1000,"stamp":1763197200}`, "r1", ""},
+		{"wrong kind", "rt", testRateLine("r1", "500", "1000", 1763197200, 100, 0), "", "missing or invalid quote_time"},
+		{"not JSON", "ri", `not json`, "", "invalid JSON"},
+		{"not an object", "ri", `[1,2]`, "", "invalid JSON"},
+		{"null", "ri", `null`, "", "not a JSON object"},
+		{"missing id", "ri", `{"util_wad":"1n","index_ray":"1n","stamp":"1n"}`, "", "missing id"},
+		{"numeric id", "ri", `{"id":1,"util_wad":"1n","index_ray":"1n","stamp":"1n"}`, "", "missing id"},
+		{"invalid value", "ri", `{"id":"r1","util_wad":"-1n","index_ray":"1n","stamp":"1n"}`, "", "missing or invalid util_wad"},
+		{"invalid log", "ri", `{"id":"r1","util_wad":"1n","index_ray":"1n","stamp":"1n","log":[]}`, "", "invalid log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := validateLog([]byte(tt.line), ingestKinds[tt.kind])
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || entry.id != tt.wantID {
+				t.Errorf("expected id %s, got %s (%v)", tt.wantID, entry.id, err)
+			}
+		})
+	}
+}
+
+func TestIngest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ri_test_ingest.db")
+	db, err := openIngestDatabase(path, ingestKinds["ri"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	input := strings.Join([]string{
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		"",
+		"not json",
+		testRateLine("r2", "600000000000000000", "1000000100000000000000000000", 1763197210, 100, 1),
+		`{"id":"r3"}`,
+		testRateLine("r3", "700000000000000000", "1000000200000000000000000000", 1763197220, 101, 0),
+		testRateLine("r1", "550000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	}, "\n")
+
+	stats, err := ingest(context.Background(), db, ingestKinds["ri"], strings.NewReader(input),
+		ingestOptions{batchSize: 2, batchInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	want := ingestStats{lines: 6, inserted: 4, skipped: 2, batches: 2}
+	if stats != want {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+
+	// Duplicate IDs replace earlier logs, and the view matches the scripts'
+	if count := countLogs(t, path); count != 3 {
+		t.Errorf("expected 3 logs, got %d", count)
+	}
+	var util float64
+	var block int
+	if err := db.QueryRow("SELECT util_e18, log_block_number FROM riw_view WHERE id = 'r1'").Scan(&util, &block); err != nil {
+		t.Fatalf("failed to query view: %v", err)
+	}
+	if util != 0.55 || block != 100 {
+		t.Errorf("expected util 0.55 at block 100, got %v at %d", util, block)
+	}
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("expected WAL journal mode, got %q (%v)", journalMode, err)
+	}
+
+	// Ingesting into an existing database keeps its logs
+	db.Close()
+	db, err = openIngestDatabase(path, ingestKinds["ri"])
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	if count := countLogs(t, path); count != 3 {
+		t.Errorf("expected 3 logs after reopening, got %d", count)
+	}
+}
+
+func TestIngestBatchInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rt_test_ingest.db")
+	db, err := openIngestDatabase(path, ingestKinds["rt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reader, writer := io.Pipe()
+	done := make(chan ingestStats, 1)
+	go func() {
+		stats, err := ingest(context.Background(), db, ingestKinds["rt"], reader,
+			ingestOptions{batchSize: 100, batchInterval: 10 * time.Millisecond})
+		if err != nil {
+			t.Errorf("ingest failed: %v", err)
+		}
+		done <- stats
+	}()
+
+	// A partial batch is committed by time while waiting for input
+	io.WriteString(writer, testQuoteLine("q1", "900000000000000000", "1100000000000000000", 1763197200, 100, 0)+"\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for countLogs(t, path) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected partial batch to be committed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	writer.Close()
+	if stats := <-done; stats.inserted != 1 || stats.batches != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestIngestCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ri_test_ingest.db")
+	db, err := openIngestDatabase(path, ingestKinds["ri"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := ingest(ctx, db, ingestKinds["ri"], reader,
+			ingestOptions{batchSize: 100, batchInterval: time.Minute})
+		done <- err
+	}()
+
+	// The pending batch is committed on cancellation (e.g. SIGTERM)
+	io.WriteString(writer, testRateLine("r1", "500", "1000", 1763197200, 100, 0)+"\n")
+	io.WriteString(writer, "{") // returns once the first line has been handed over
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	if count := countLogs(t, path); count != 1 {
+		t.Errorf("expected 1 committed log, got %d", count)
+	}
+}
+
+func TestRunIngest(t *testing.T) {
+	origLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(origLogger) })
+
+	path := filepath.Join(t.TempDir(), "rt_test_ingest.db")
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"missing kind", []string{path}, 2},
+		{"invalid kind", []string{"--kind=xx", path}, 2},
+		{"missing database", []string{"--kind=rt"}, 2},
+		{"invalid batch size", []string{"--kind=rt", "--batch-size=0", path}, 2},
+		{"invalid flag", []string{"--kind=rt", "--unknown", path}, 2},
+		{"help", []string{"--help"}, 0},
+		{"ingest", []string{"--kind=rt", "--log-format=json", path}, 0},
+		{"short flags", []string{"-k", "rt", "-B", "1", "-I", "1s", path}, 0},
+	}
+
+	line := testQuoteLine("q1", "900000000000000000", "1100000000000000000", 1763197200, 100, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slog.SetDefault(origLogger)
+			if code := runIngest(tt.args, strings.NewReader(line+"\n")); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+
+	if count := countLogs(t, path); count != 1 {
+		t.Errorf("expected 1 log, got %d", count)
+	}
+}
+
+func TestIngestReorg(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ri_test_reorg.db")
+	db, err := openIngestDatabase(path, ingestKinds["ri"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Block 100 (hash 0x64) is replaced by a reorg with hash 0xc8
+	orphaned := func(line string) string {
+		return strings.Replace(line, `"blockHash":"0x64"`, `"blockHash":"0xc8"`, 1)
+	}
+	input := strings.Join([]string{
+		testRateLine("r1", "500", "1000", 1763197200, 100, 0),
+		testRateLine("r2", "600", "1000", 1763197210, 100, 1),
+		testRateLine("r3", "700", "1000", 1763197220, 101, 0),
+		strings.Replace(orphaned(testRateLine("r1", "500", "1000", 1763197200, 100, 0)),
+			`"removed":false`, `"removed":true`, 1), // removal notices are not checked
+		orphaned(testRateLine("r4", "800", "1000", 1763197200, 100, 0)),
+		orphaned(testRateLine("r5", "900", "1000", 1763197210, 100, 1)),
+	}, "\n")
+
+	stats, err := ingest(context.Background(), db, ingestKinds["ri"], strings.NewReader(input),
+		ingestOptions{batchSize: 100, batchInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	if stats.tombstoned != 1 || stats.inserted != 6 {
+		t.Errorf("expected 1 tombstoned and 6 inserted logs, got %+v", stats)
+	}
+
+	rows, err := db.Query("SELECT id, log_block_hash, log_removed FROM riw_view ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, hash string
+		var removed int
+		rows.Scan(&id, &hash, &removed)
+		got = append(got, id+":"+hash+":"+strconv.Itoa(removed))
+	}
+	want := "r1:0xc8:1 r2:0x64:1 r3:0x65:0 r4:0xc8:0 r5:0xc8:0"
+	if strings.Join(got, " ") != want {
+		t.Errorf("expected logs %s, got %s", want, strings.Join(got, " "))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// requestIDRegex validates request IDs passed in by clients or proxies
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// setupLogger installs the default slog logger (which the log package then
+// writes through) with text or JSON output
+func setupLogger(format string, w io.Writer) error {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, nil)
+	case "json":
+		handler = slog.NewJSONHandler(w, nil)
+	default:
+		return fmt.Errorf("invalid log format: %s (expected text or json)", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newRequestID returns a random 128-bit request ID in hex
+func newRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// requestIDMiddleware adopts a valid X-Request-ID header (e.g. from nginx) or
+// generates a new ID, echoes it in the response and stores it in the context
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRegex.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestID returns the ID of a request (empty outside requestIDMiddleware)
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns the default logger with the request ID (and the name of
+// the API client) attached
+func requestLog(r *http.Request) *slog.Logger {
+	logger := slog.Default().With("request_id", requestID(r))
+	if client := requestClient(r); client != nil && client != invalidClient {
+		logger = logger.With("api_client", client.name)
+	}
+	return logger
+}
+
+// clientIP returns the client address set by a trusted proxy (e.g. nginx) in
+// X-Real-IP or else as the last untrusted X-Forwarded-For address, or the
+// remote address of the connection
+func clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !trustedProxy(remote) {
+		return remote
+	}
+
+	if ip := r.Header.Get("X-Real-IP"); net.ParseIP(ip) != nil {
+		return ip
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !trustedProxy(ip) {
+			return ip
+		}
+	}
+	return remote
+}
+
+// trustedProxy reports whether an address belongs to a trusted proxy
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessLogMiddleware logs every request with its route pattern, database,
+// status, response size and duration
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		started := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requestLog(r).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"db", chi.URLParam(r, "dbName"),
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(started),
+			"client_ip", clientIP(r),
+		)
+	})
+}
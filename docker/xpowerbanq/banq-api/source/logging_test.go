@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// captureLogs routes the default logger to a JSON buffer for the test's duration
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	orig := slog.Default()
+	var buf bytes.Buffer
+	if err := setupLogger("json", &buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { slog.SetDefault(orig) })
+	return &buf
+}
+
+// logRecords decodes the JSON log records of a buffer
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSetupLogger(t *testing.T) {
+	orig := slog.Default()
+	defer slog.SetDefault(orig)
+
+	for _, format := range []string{"text", "json"} {
+		var buf bytes.Buffer
+		if err := setupLogger(format, &buf); err != nil {
+			t.Errorf("setupLogger(%q) failed: %v", format, err)
+		}
+		slog.Info("hello")
+		if !strings.Contains(buf.String(), "hello") {
+			t.Errorf("setupLogger(%q): expected log output, got %q", format, buf.String())
+		}
+	}
+	if err := setupLogger("xml", &bytes.Buffer{}); err == nil {
+		t.Error("expected error for invalid log format")
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		echoed bool
+	}{
+		{"no header", "", false},
+		{"valid header", "abc-123.def", true},
+		{"invalid header", "abc 123\n", false},
+		{"overlong header", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			id := rr.Header().Get("X-Request-ID")
+			if id == "" || id != seen {
+				t.Fatalf("expected echoed ID %q to match context ID %q", id, seen)
+			}
+			if tt.echoed && id != tt.header {
+				t.Errorf("expected ID %q, got %q", tt.header, id)
+			}
+			if !tt.echoed && len(id) != 32 {
+				t.Errorf("expected generated 32-digit ID, got %q", id)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		remote    string
+		realIP    string
+		forwarded string
+		expected  string
+	}{
+		{"X-Real-IP", "127.0.0.1:1234", "203.0.113.7", "", "203.0.113.7"},
+		{"IPv6 X-Real-IP", "[::1]:1234", "2001:db8::1", "", "2001:db8::1"},
+		{"invalid X-Real-IP", "127.0.0.1:1234", "not-an-ip", "", "127.0.0.1"},
+		{"no X-Real-IP", "127.0.0.1:1234", "", "", "127.0.0.1"},
+		{"untrusted proxy", "192.0.2.1:1234", "203.0.113.7", "203.0.113.8", "192.0.2.1"},
+		{"X-Forwarded-For", "172.17.0.1:1234", "", "203.0.113.8, 203.0.113.7", "203.0.113.7"},
+		{"X-Forwarded-For via proxies", "127.0.0.1:1234", "", "203.0.113.7, 172.18.0.2", "203.0.113.7"},
+		{"spoofed X-Forwarded-For", "127.0.0.1:1234", "", "not-an-ip, 127.0.0.2", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if ip := clientIP(req); ip != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, ip)
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	buf := captureLogs(t)
+
+	r := chi.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(accessLogMiddleware)
+	registerAPIRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/ri_missing/daily_average.json?lhs=2025-11-15&rhs=2025-12-15", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	req.RemoteAddr = "127.0.0.1:1234"
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", rr.Code)
+	}
+
+	records := logRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("expected error and access log records, got %d: %s", len(records), buf.String())
+	}
+
+	// Error log carries the request ID
+	if records[0]["msg"] != "Database error" || records[0]["request_id"] != "req-1" {
+		t.Errorf("unexpected error record: %v", records[0])
+	}
+
+	access := records[1]
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"request_id": "req-1",
+		"method":     "GET",
+		"route":      "/{dbName}/daily_average.json",
+		"db":         "ri_missing",
+		"status":     float64(http.StatusServiceUnavailable),
+		"bytes":      float64(rr.Body.Len()),
+		"client_ip":  "203.0.113.7",
+	}
+	for key, value := range expected {
+		if access[key] != value {
+			t.Errorf("access log %s = %v, want %v", key, access[key], value)
+		}
+	}
+	if _, ok := access["duration"]; !ok {
+		t.Error("access log missing duration")
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -19,54 +23,125 @@ func getAllowedOriginsSlice() []string {
 }
 
 func main() {
-	// Configure logger
-	log.SetFlags(log.Ldate | log.Ltime)
-	log.SetOutput(os.Stderr)
+	// Run subcommands (e.g. banq-api ingest --kind=ri <db>)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ingest":
+			os.Exit(runIngest(os.Args[2:], os.Stdin))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:], os.Stdout))
+		case "backfill":
+			os.Exit(runBackfill(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		}
+	}
 
 	// Parse command-line arguments
 	parseArgs()
 
-	log.Printf("XPower Banq API starting...")
-	log.Printf("Database path: %s", dbPath)
-	log.Printf("Max rows per query: %d", maxRows)
+	// Configure logger
+	if err := setupLogger(logFormat, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	slog.Info("XPower Banq API starting...",
+		"db_path", dbPath,
+		"max_rows", maxRows,
+		"cache_size", cacheSize,
+		"cache_ttl", cacheTTL,
+	)
+
+	// Create query result cache
+	queryCache = newResultCache(cacheSize, cacheTTL)
 
 	// Validate databases at startup
 	if err := validateDatabases(); err != nil {
-		log.Fatalf("Database validation failed: %v", err)
+		slog.Error("Database validation failed", "err", err)
+		os.Exit(1)
+	}
+	if _, err := scanDatabases(); err != nil {
+		slog.Error("Database scan failed", "err", err)
+		os.Exit(1)
 	}
 
 	// Create Chi router
 	r := chi.NewRouter()
 
+	// Tag requests with an ID and log them (including CORS preflights)
+	r.Use(requestIDMiddleware)
+	r.Use(apiKeyMiddleware)
+	r.Use(accessLogMiddleware)
+
+	// Record request metrics (including CORS preflights)
+	r.Use(metricsMiddleware)
+
 	// Setup CORS middleware
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   getAllowedOriginsSlice(),
 		AllowedMethods:   []string{"GET", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type"},
-		ExposedHeaders:   []string{"Content-Type", "X-Database"},
+		AllowedHeaders:   []string{"Content-Type", "If-None-Match", "If-Modified-Since", "Last-Event-ID", "X-Request-ID", "X-API-Key"},
+		ExposedHeaders:   []string{"Content-Type", "X-Database", "ETag", "Last-Modified", "X-Data-Age", "X-Request-ID", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           3600,
 	}))
 
 	// Register static routes
 	r.Get("/health", handleHealth)
+	r.Get("/ready", handleReady)
 	r.Get("/robots.txt", handleRobots)
+	r.With(apiAccessMiddleware(""), rateLimitMiddleware(1)).Get("/databases.json", handleDatabases)
+	r.With(apiAccessMiddleware(""), rateLimitMiddleware(1)).Get("/openapi.json", handleOpenAPI)
+
+	// Serve metrics only on a separate address (not exposed via nginx) if
+	// configured, never publicly on the API port
+	servers := []*http.Server{newServer(":"+listenPort, r)}
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", handleMetrics)
+		servers = append(servers, newServer(metricsAddr, mux))
+	}
 	r.Get("/", handleRoot)
 
-	// Register dynamic API routes from endpointRoutes map
+	// Register declarative query endpoints and dynamic API routes from
+	// endpointRoutes map
+	if queriesPath != "" {
+		if err := loadQueries(queriesPath); err != nil {
+			slog.Error("Query loading failed", "err", err)
+			os.Exit(1)
+		}
+	}
 	registerAPIRoutes(r)
 
-	// Log allowed origins
-	log.Println("CORS allowed origins:")
-	for origin := range allowedOrigins {
-		log.Printf("  - %s", origin)
+	// Load API keys (after the routes their tiers may refer to)
+	if apiKeysPath != "" {
+		if err := reloadAPIKeys(apiKeysPath, true); err != nil {
+			slog.Error("API key loading failed", "err", err)
+			os.Exit(1)
+		}
 	}
 
-	// Start server
-	addr := ":" + listenPort
-	log.Printf("Starting XPower Banq API server on %s", addr)
+	// Log allowed origins
+	slog.Info("CORS allowed origins", "origins", getAllowedOriginsSlice())
+
+	// Start servers (until SIGTERM or SIGINT, e.g. by docker stop)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// Watch for added, removed or replaced databases (rescan on SIGHUP)
+	rescan := make(chan os.Signal, 1)
+	signal.Notify(rescan, syscall.SIGHUP)
+	go watchDatabases(ctx, watchInterval, rescan)
+
+	// Watch for API key changes (reload on SIGHUP)
+	if apiKeysPath != "" {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go watchAPIKeys(ctx, apiKeysPath, watchInterval, reload)
+	}
 
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatal(err)
+	if err := serve(ctx, servers...); err != nil {
+		os.Exit(1)
 	}
 }
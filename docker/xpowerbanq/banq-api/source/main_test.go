@@ -1,16 +1,20 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 // TestMain runs before all tests and sets up the test environment
 func TestMain(m *testing.M) {
 	// Suppress log output during tests to avoid confusing error messages
-	log.SetOutput(io.Discard)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
 
 	// Run tests
 	exitCode := m.Run()
@@ -18,3 +22,54 @@ func TestMain(m *testing.M) {
 	// Exit with the test result code
 	os.Exit(exitCode)
 }
+
+// createTestDatabase creates dir/dbName.db with the ingest schema (of rt
+// logs for rt_ names, else of ri logs) and the given JSON log lines, and
+// points dbPath at dir for the test's duration
+func createTestDatabase(t *testing.T, dir string, dbName string, lines ...string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, dbName+".db"))
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	kind := "ri"
+	if strings.HasPrefix(dbName, "rt_") {
+		kind = "rt"
+	}
+	if _, err := migrateDatabase(db, ingestKinds[kind]); err != nil {
+		t.Fatalf("failed to create test schema: %v", err)
+	}
+	for _, line := range lines {
+		if _, err := db.Exec(
+			"INSERT OR REPLACE INTO raw_logs(id, json) VALUES(json_extract(?1,'$.id'), ?1)", line,
+		); err != nil {
+			t.Fatalf("failed to insert test data: %v", err)
+		}
+	}
+
+	origDbPath := dbPath
+	dbPath = dir
+	t.Cleanup(func() {
+		dbPath = origDbPath
+		evictDatabase(dbName)
+	})
+}
+
+// testQuoteLine builds an rt_ JSON log line as emitted by `banq rt`
+func testQuoteLine(id string, bid, ask string, quoteTime int64, block, index int) string {
+	return fmt.Sprintf(
+		`{"id":%q,"quote_bid":"%sn","quote_ask":"%sn","quote_time":"%dn","log":{"blockHash":"0x%x","blockNumber":%d,"index":%d,"removed":false,"transactionHash":"0x%x%d"}}`,
+		id, bid, ask, quoteTime, block, block, index, block, index,
+	)
+}
+
+// testRateLine builds an ri_ JSON log line as emitted by `banq ri`
+func testRateLine(id string, util, index string, stamp int64, block, logIndex int) string {
+	return fmt.Sprintf(
+		`{"id":%q,"util_wad":"%sn","index_ray":"%sn","stamp":"%dn","log":{"blockHash":"0x%x","blockNumber":%d,"index":%d,"removed":false,"transactionHash":"0x%x%d"}}`,
+		id, util, index, stamp, block, block, logIndex, block, logIndex,
+	)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of latency histograms
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations per latency bucket
+type histogram struct {
+	counts []uint64 // per bucket of latencyBuckets (not cumulative)
+	count  uint64
+	sum    float64
+}
+
+// requestKey labels HTTP request metrics
+type requestKey struct {
+	route  string
+	status int
+}
+
+// cancelKey labels interrupted queries by database name and reason (timeout
+// or client)
+type cancelKey struct {
+	dbName string
+	reason string
+}
+
+// metricsRegistry collects request, query and scanner metrics
+type metricsRegistry struct {
+	mux              sync.Mutex
+	requests         map[requestKey]*histogram
+	queries          map[string]*histogram // by database name
+	scannedRows      map[string]uint64     // by database name
+	queryErrors      map[string]uint64     // by database name
+	queryCancels     map[cancelKey]uint64
+	requestsInFlight int64
+}
+
+var (
+	// Metrics registry (reset by tests)
+	metrics = newMetricsRegistry()
+)
+
+// newMetricsRegistry creates an empty metrics registry
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		requests:     make(map[requestKey]*histogram),
+		queries:      make(map[string]*histogram),
+		scannedRows:  make(map[string]uint64),
+		queryErrors:  make(map[string]uint64),
+		queryCancels: make(map[cancelKey]uint64),
+	}
+}
+
+// observe adds an observation to a histogram
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeRequest records a served HTTP request
+func (m *metricsRegistry) observeRequest(route string, status int, d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	key := requestKey{route: route, status: status}
+	if m.requests[key] == nil {
+		m.requests[key] = &histogram{}
+	}
+	m.requests[key].observe(d)
+}
+
+// observeQuery records a query on a database and the rows its scanner returned
+func (m *metricsRegistry) observeQuery(dbName string, d time.Duration, results interface{}, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.queries[dbName] == nil {
+		m.queries[dbName] = &histogram{}
+	}
+	m.queries[dbName].observe(d)
+	m.scannedRows[dbName] += uint64(resultRows(results))
+	switch {
+	case errors.Is(err, errQueryTimeout):
+		m.queryCancels[cancelKey{dbName: dbName, reason: "timeout"}]++
+	case errors.Is(err, errQueryCanceled):
+		m.queryCancels[cancelKey{dbName: dbName, reason: "client"}]++
+	case err != nil && !errors.Is(err, errNoData):
+		m.queryErrors[dbName]++
+	}
+}
+
+// resultRows returns the number of rows of scanned results
+func resultRows(results interface{}) int {
+	if results == nil {
+		return 0
+	}
+	if rows := reflect.ValueOf(results); rows.Kind() == reflect.Slice {
+		return rows.Len()
+	}
+	return 1
+}
+
+// metricsMiddleware records the count, status and latency of requests by
+// route pattern (e.g. "/{dbName}/daily_average.json")
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		started := time.Now()
+
+		metrics.mux.Lock()
+		metrics.requestsInFlight++
+		metrics.mux.Unlock()
+		defer func() {
+			metrics.mux.Lock()
+			metrics.requestsInFlight--
+			metrics.mux.Unlock()
+		}()
+
+		next.ServeHTTP(ww, r)
+
+		// Unmatched paths share a label to bound cardinality
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.observeRequest(route, status, time.Since(started))
+	})
+}
+
+// handleMetrics serves metrics in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	metrics.write(w)
+}
+
+// write writes all metrics in the Prometheus text exposition format
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// HTTP requests by route pattern and status
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].status < keys[j].status
+	})
+	writeHeader(w, "banq_http_requests_total", "counter", "HTTP requests by route pattern and status")
+	for _, key := range keys {
+		fmt.Fprintf(w, "banq_http_requests_total{%s} %d\n", requestLabels(key), m.requests[key].count)
+	}
+	writeHeader(w, "banq_http_request_duration_seconds", "histogram", "HTTP request latency by route pattern and status")
+	for _, key := range keys {
+		writeHistogram(w, "banq_http_request_duration_seconds", requestLabels(key), m.requests[key])
+	}
+	writeHeader(w, "banq_http_requests_in_flight", "gauge", "HTTP requests currently being served")
+	fmt.Fprintf(w, "banq_http_requests_in_flight %d\n", m.requestsInFlight)
+
+	// Queries and scanned rows by database
+	dbNames := make([]string, 0, len(m.queries))
+	for dbName := range m.queries {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+	writeHeader(w, "banq_query_duration_seconds", "histogram", "Query and scan latency by database")
+	for _, dbName := range dbNames {
+		writeHistogram(w, "banq_query_duration_seconds", dbLabel(dbName), m.queries[dbName])
+	}
+	writeHeader(w, "banq_query_errors_total", "counter", "Failed queries by database")
+	for _, dbName := range dbNames {
+		fmt.Fprintf(w, "banq_query_errors_total{%s} %d\n", dbLabel(dbName), m.queryErrors[dbName])
+	}
+	cancels := make([]cancelKey, 0, len(m.queryCancels))
+	for key := range m.queryCancels {
+		cancels = append(cancels, key)
+	}
+	sort.Slice(cancels, func(i, j int) bool {
+		if cancels[i].dbName != cancels[j].dbName {
+			return cancels[i].dbName < cancels[j].dbName
+		}
+		return cancels[i].reason < cancels[j].reason
+	})
+	writeHeader(w, "banq_query_cancellations_total", "counter", "Queries interrupted by timeout or client by database")
+	for _, key := range cancels {
+		fmt.Fprintf(w, "banq_query_cancellations_total{%s,reason=%q} %d\n", dbLabel(key.dbName), key.reason, m.queryCancels[key])
+	}
+	writeHeader(w, "banq_scanned_rows_total", "counter", "Rows returned by result scanners by database")
+	for _, dbName := range dbNames {
+		fmt.Fprintf(w, "banq_scanned_rows_total{%s} %d\n", dbLabel(dbName), m.scannedRows[dbName])
+	}
+
+	writePoolStats(w)
+}
+
+// writePoolStats writes the sql.DBStats of every pooled database
+func writePoolStats(w io.Writer) {
+	dbMux.RLock()
+	stats := make(map[string]sql.DBStats, len(dbPool))
+	dbNames := make([]string, 0, len(dbPool))
+	for dbName, db := range dbPool {
+		stats[dbName] = db.Stats()
+		dbNames = append(dbNames, dbName)
+	}
+	dbMux.RUnlock()
+	sort.Strings(dbNames)
+
+	poolMetrics := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) float64
+	}{
+		{"banq_db_max_open_connections", "gauge", "Maximum number of open connections",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"banq_db_open_connections", "gauge", "Number of open connections",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"banq_db_in_use_connections", "gauge", "Number of connections in use",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"banq_db_idle_connections", "gauge", "Number of idle connections",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"banq_db_wait_count_total", "counter", "Connections waited for",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"banq_db_wait_duration_seconds_total", "counter", "Time spent waiting for connections",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"banq_db_idle_closed_total", "counter", "Connections closed due to idle limits",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed + s.MaxIdleTimeClosed) }},
+		{"banq_db_lifetime_closed_total", "counter", "Connections closed due to their maximum lifetime",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	for _, metric := range poolMetrics {
+		writeHeader(w, metric.name, metric.kind, metric.help+" by database")
+		for _, dbName := range dbNames {
+			fmt.Fprintf(w, "%s{%s} %g\n", metric.name, dbLabel(dbName), metric.value(stats[dbName]))
+		}
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of a histogram
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// labelEscaper escapes label values of the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// requestLabels formats the labels of HTTP request metrics
+func requestLabels(key requestKey) string {
+	return fmt.Sprintf(`route="%s",status="%d"`, labelEscaper.Replace(key.route), key.status)
+}
+
+// dbLabel formats the database label of query and pool metrics
+func dbLabel(dbName string) string {
+	return fmt.Sprintf(`db="%s"`, labelEscaper.Replace(dbName))
+}
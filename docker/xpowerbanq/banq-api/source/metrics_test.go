@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// resetMetrics replaces the metrics registry for the duration of a test
+func resetMetrics(t *testing.T) {
+	orig := metrics
+	metrics = newMetricsRegistry()
+	t.Cleanup(func() { metrics = orig })
+}
+
+// scrapeMetrics returns the metrics text served by handleMetrics
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	return rr.Body.String()
+}
+
+// assertMetric checks that the metrics text contains a sample line
+func assertMetric(t *testing.T, text, sample string) {
+	t.Helper()
+	for _, line := range strings.Split(text, "\n") {
+		if line == sample {
+			return
+		}
+	}
+	t.Errorf("expected metric %q in:\n%s", sample, text)
+}
+
+func TestHistogramObserve(t *testing.T) {
+	var h histogram
+	h.observe(500 * time.Microsecond)
+	h.observe(30 * time.Millisecond)
+	h.observe(time.Minute)
+
+	var out strings.Builder
+	writeHistogram(&out, "test_seconds", `db="x"`, &h)
+	text := out.String()
+
+	assertMetric(t, text, `test_seconds_bucket{db="x",le="0.001"} 1`)
+	assertMetric(t, text, `test_seconds_bucket{db="x",le="0.025"} 1`)
+	assertMetric(t, text, `test_seconds_bucket{db="x",le="0.05"} 2`)
+	assertMetric(t, text, `test_seconds_bucket{db="x",le="10"} 2`)
+	assertMetric(t, text, `test_seconds_bucket{db="x",le="+Inf"} 3`)
+	assertMetric(t, text, `test_seconds_count{db="x"} 3`)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	resetMetrics(t)
+
+	r := chi.NewRouter()
+	r.Use(metricsMiddleware)
+	registerAPIRoutes(r)
+
+	for _, path := range []string{
+		"/xx_test/daily_average.json?lhs=2025-11-15&rhs=2025-12-15",
+		"/xx_test/daily_average.json?lhs=2025-11-15&rhs=2025-12-15",
+		"/unknown/path",
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	text := scrapeMetrics(t)
+	assertMetric(t, text, `banq_http_requests_total{route="/{dbName}/daily_average.json",status="400"} 2`)
+	assertMetric(t, text, `banq_http_requests_total{route="unmatched",status="404"} 1`)
+	assertMetric(t, text, `banq_http_request_duration_seconds_count{route="/{dbName}/daily_average.json",status="400"} 2`)
+	assertMetric(t, text, `banq_http_requests_in_flight 0`)
+}
+
+func TestMetricsQueriesAndPools(t *testing.T) {
+	resetMetrics(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "rt_test_metrics",
+		testQuoteLine("q1", "1000000000000000000", "2000000000000000000", 1763197200, 100, 0),
+		testQuoteLine("q2", "1000000000000000000", "2000000000000000000", 1763200800, 101, 0),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+	req := httptest.NewRequest(http.MethodGet, "/rt_test_metrics/events.json", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	text := scrapeMetrics(t)
+	assertMetric(t, text, `banq_query_duration_seconds_count{db="rt_test_metrics"} 1`)
+	assertMetric(t, text, `banq_query_errors_total{db="rt_test_metrics"} 0`)
+	assertMetric(t, text, `banq_scanned_rows_total{db="rt_test_metrics"} 2`)
+	if !strings.Contains(text, `banq_db_open_connections{db="rt_test_metrics"} `) {
+		t.Errorf("expected pool stats of rt_test_metrics in:\n%s", text)
+	}
+}
+
+func TestResultRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  interface{}
+		expected int
+	}{
+		{"nil", nil, 0},
+		{"empty slice", []DailyAverage{}, 0},
+		{"slice", []DailyAverage{{}, {}}, 2},
+		{"single record", LatestRate{}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := resultRows(tt.results); n != tt.expected {
+				t.Errorf("expected %d rows, got %d", tt.expected, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// schemaVersion is the PRAGMA user_version of databases with typed columns
+// (databases created by the former ingest scripts have version 0)
+const schemaVersion = 1
+
+// rawLogsSQL creates the table of JSON logs of the former ingest scripts
+// (to be migrated)
+const rawLogsSQL = `
+CREATE TABLE IF NOT EXISTS raw_logs (
+  id TEXT NOT NULL PRIMARY KEY,
+  json TEXT NOT NULL
+);`
+
+// typedLogsSQL creates the table of JSON logs with the typed columns common
+// to all kinds, followed by those of a kind; SQLite materializes them on
+// every insert and update (including tombstones), so they stay in sync with
+// the JSON of a log
+const typedLogsSQL = `
+CREATE TABLE raw_logs_typed (
+  id TEXT NOT NULL PRIMARY KEY,
+  json TEXT NOT NULL,
+  log_block_number INTEGER GENERATED ALWAYS AS (CAST(json_extract(json,'$.log.blockNumber') AS INTEGER)) STORED,
+  log_index INTEGER GENERATED ALWAYS AS (CAST(json_extract(json,'$.log.index') AS INTEGER)) STORED,
+  log_removed INTEGER GENERATED ALWAYS AS (CAST(json_extract(json,'$.log.removed') AS INTEGER)) STORED,
+  log_block_hash TEXT GENERATED ALWAYS AS (json_extract(json,'$.log.blockHash')) STORED,
+  log_tx_hash TEXT GENERATED ALWAYS AS (json_extract(json,'$.log.transactionHash')) STORED,%s
+);`
+
+// legacyIndexesSQL drops the expression indexes of the former ingest scripts
+// (which the typed columns replace)
+const legacyIndexesSQL = `
+DROP INDEX IF EXISTS idx_block_number;
+DROP INDEX IF EXISTS idx_stamp;
+DROP INDEX IF EXISTS idx_quote_time;`
+
+// logPositionSQL indexes logs by block number and log index (common to all
+// kinds)
+const logPositionSQL = `
+CREATE INDEX IF NOT EXISTS idx_log_position
+  ON raw_logs (log_block_number, log_index);`
+
+// schemaVersionOf returns the schema version of a database
+func schemaVersionOf(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// checkSchema checks that a database has typed columns
+func checkSchema(db *sql.DB) error {
+	version, err := schemaVersionOf(db)
+	if err != nil {
+		return err
+	}
+	if version < schemaVersion {
+		return fmt.Errorf("schema version %d is outdated (run banq-api migrate)", version)
+	}
+	return nil
+}
+
+// migrateDatabase creates the typed schema of a kind, or migrates the
+// schema of the former ingest scripts to it by rebuilding raw_logs (keeping
+// rowids, which are the event IDs of streams), and reports whether it did
+func migrateDatabase(db *sql.DB, kind ingestKind) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= schemaVersion {
+		return false, nil
+	}
+
+	for _, stmt := range []string{
+		rawLogsSQL,
+		"DROP VIEW IF EXISTS " + kind.view,
+		legacyIndexesSQL,
+		fmt.Sprintf(typedLogsSQL, kind.columnsSQL),
+		"INSERT INTO raw_logs_typed(rowid, id, json) SELECT rowid, id, json FROM raw_logs",
+		"DROP TABLE raw_logs",
+		"ALTER TABLE raw_logs_typed RENAME TO raw_logs",
+		logPositionSQL + kind.schemaSQL,
+		fmt.Sprintf("PRAGMA user_version = %d", schemaVersion),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return false, fmt.Errorf("migration failed: %v", err)
+		}
+	}
+	return true, tx.Commit()
+}
+
+// databaseKind returns the kind of a database file: the given kind, or else
+// the kind of its ri_ or rt_ name prefix
+func databaseKind(kind, path string) (string, error) {
+	if kind == "" {
+		kind = strings.TrimSuffix(catalogPrefix(strings.TrimSuffix(filepath.Base(path), ".db")), "_")
+	}
+	if _, exists := ingestKinds[kind]; !exists {
+		return "", fmt.Errorf("unknown kind of %s (use --kind=ri or --kind=rt)", path)
+	}
+	return kind, nil
+}
+
+// runMigrate runs the migrate subcommand, returning its exit status
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+
+	kindPtr := flags.String("k", "", "Kind of logs (ri or rt)")
+	flags.StringVar(kindPtr, "kind", "", "Kind of logs (ri or rt)")
+
+	logFormatPtr := flags.String("L", logFormat, "Log output format (text or json)")
+	flags.StringVar(logFormatPtr, "log-format", logFormat, "Log output format (text or json)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s migrate [options] <db>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Migrate a database of the former ingest scripts to typed columns\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "  -k, --kind string\n")
+		fmt.Fprintf(os.Stderr, "        Kind of logs, ri or rt (default: by database name prefix)\n")
+		fmt.Fprintf(os.Stderr, "  -L, --log-format string\n")
+		fmt.Fprintf(os.Stderr, "        Log output format, text or json (default: %s)\n", logFormat)
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+	kind, err := databaseKind(*kindPtr, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := setupLogger(*logFormatPtr, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	started := time.Now()
+	db, err := sql.Open("sqlite3", fmt.Sprintf(ingestDSN, path))
+	if err != nil {
+		slog.Error("Migration failed", "db", path, "err", err)
+		return 1
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	migrated, err := migrateDatabase(db, ingestKinds[kind])
+	if err != nil {
+		slog.Error("Migration failed", "db", path, "err", err)
+		return 1
+	}
+	if !migrated {
+		slog.Info("Database is up to date", "db", path, "version", schemaVersion)
+		return 0
+	}
+	slog.Info("Database migrated", "db", path, "version", schemaVersion, "duration", time.Since(started))
+	return 0
+}
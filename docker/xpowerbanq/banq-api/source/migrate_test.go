@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyRateSchemaSQL mirrors the schema created by the former
+// banq-riw2db.sh script (JSON views and expression indexes)
+const legacyRateSchemaSQL = `
+	CREATE TABLE IF NOT EXISTS raw_logs (
+		id TEXT NOT NULL PRIMARY KEY,
+		json TEXT NOT NULL
+	);
+	CREATE VIEW IF NOT EXISTS riw_view AS
+		SELECT
+			json_extract(json,'$.id') AS id,
+			(REPLACE(json_extract(json,'$.index_ray'),'n','')+0.0)/1e27 AS index_e27,
+			json_extract(json,'$.index_ray') AS index_ray,
+			(REPLACE(json_extract(json,'$.util_wad'),'n','')+0.0)/1e18 AS util_e18,
+			json_extract(json,'$.util_wad') AS util_wad,
+			datetime(CAST(REPLACE(json_extract(json,'$.stamp'),'n','') AS INTEGER),'unixepoch') AS stamp_iso,
+			json_extract(json,'$.stamp') AS stamp,
+			json_extract(json,'$.log.blockHash') AS log_block_hash,
+			CAST(json_extract(json,'$.log.blockNumber') AS INTEGER) AS log_block_number,
+			CAST(json_extract(json,'$.log.index') AS INTEGER) AS log_index,
+			CAST(json_extract(json,'$.log.removed') AS INTEGER) AS log_removed,
+			json_extract(json,'$.log.transactionHash') AS log_tx_hash,
+			json
+		FROM raw_logs;
+	CREATE INDEX IF NOT EXISTS idx_block_number
+		ON raw_logs (CAST(json_extract(json,'$.log.blockNumber') AS INTEGER));
+	CREATE INDEX IF NOT EXISTS idx_stamp
+		ON raw_logs (CAST(REPLACE(json_extract(json,'$.stamp'),'n','') AS INTEGER));
+`
+
+// createLegacyDatabase creates a database of the former ingest scripts with
+// the given JSON log lines
+func createLegacyDatabase(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(legacyRateSchemaSQL); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	for _, line := range lines {
+		if _, err := db.Exec(
+			"INSERT OR REPLACE INTO raw_logs(id, json) VALUES(json_extract(?1,'$.id'), ?1)", line,
+		); err != nil {
+			t.Fatalf("failed to insert test data: %v", err)
+		}
+	}
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ri_test_migrate.db")
+	createLegacyDatabase(t, path,
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "600000000000000000", "1100000000000000000000000000", 1763200800, 101, 0),
+		testRateLine("r3", "700000000000000000", "1200000000000000000000000000", 1763204400, 102, 0),
+	)
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Rowids with a gap, which the rebuilt table must keep
+	if _, err := db.Exec("DELETE FROM raw_logs WHERE id = 'r2'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(db); err == nil {
+		t.Error("expected the legacy schema to be outdated")
+	}
+
+	migrated, err := migrateDatabase(db, ingestKinds["ri"])
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if !migrated {
+		t.Error("expected the database to be migrated")
+	}
+	if err := checkSchema(db); err != nil {
+		t.Errorf("expected the schema to be current, got %v", err)
+	}
+
+	rows, err := db.Query("SELECT rowid, id, stamp_unix, util_e18, log_block_number FROM raw_logs ORDER BY rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type typedLog struct {
+		rowid int64
+		id    string
+		stamp int64
+		util  float64
+		block int64
+	}
+	var got []typedLog
+	for rows.Next() {
+		var l typedLog
+		if err := rows.Scan(&l.rowid, &l.id, &l.stamp, &l.util, &l.block); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, l)
+	}
+	want := []typedLog{{1, "r1", 1763197200, 0.5, 100}, {3, "r3", 1763204400, 0.7, 102}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+
+	// The view keeps the columns of the former one
+	var stampISO string
+	if err := db.QueryRow("SELECT stamp_iso FROM riw_view WHERE id = 'r3'").Scan(&stampISO); err != nil {
+		t.Fatalf("failed to query view: %v", err)
+	}
+	if stampISO != "2025-11-15 11:00:00" {
+		t.Errorf("expected stamp_iso 2025-11-15 11:00:00, got %s", stampISO)
+	}
+
+	// The expression indexes are replaced by indexes on typed columns
+	var indexes []string
+	indexRows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexRows.Close()
+	for indexRows.Next() {
+		var name string
+		indexRows.Scan(&name)
+		indexes = append(indexes, name)
+	}
+	if strings.Join(indexes, ",") != "idx_log_position,idx_stamp_unix" {
+		t.Errorf("unexpected indexes %v", indexes)
+	}
+
+	// Inserts of the former scripts and tombstones keep the typed columns in sync
+	if _, err := db.Exec(
+		"INSERT INTO raw_logs(id, json) VALUES('r4', ?)",
+		testRateLine("r4", "800000000000000000", "1300000000000000000000000000", 1763208000, 103, 0),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(tombstoneSQL, 103, "0xdead"); err != nil {
+		t.Fatal(err)
+	}
+	var stamp, removed int64
+	if err := db.QueryRow("SELECT stamp_unix, log_removed FROM raw_logs WHERE id = 'r4'").Scan(&stamp, &removed); err != nil {
+		t.Fatal(err)
+	}
+	if stamp != 1763208000 || removed != 1 {
+		t.Errorf("expected stamp 1763208000 and removed 1, got %d and %d", stamp, removed)
+	}
+
+	// Migrations are idempotent
+	migrated, err = migrateDatabase(db, ingestKinds["ri"])
+	if err != nil || migrated {
+		t.Errorf("expected no migration, got %v, %v", migrated, err)
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	origLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(origLogger) })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ri_test_migrate.db")
+	createLegacyDatabase(t, path,
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	)
+	other := filepath.Join(dir, "apow.db")
+	createLegacyDatabase(t, other)
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"migrate", []string{path}, 0},
+		{"up to date", []string{path}, 0},
+		{"explicit kind", []string{"--kind=ri", other}, 0},
+		{"unknown kind", []string{filepath.Join(dir, "unknown.db")}, 2},
+		{"missing database", []string{filepath.Join(dir, "ri_missing.db")}, 2},
+		{"missing argument", []string{}, 2},
+		{"invalid flag", []string{"--unknown", path}, 2},
+		{"help", []string{"--help"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runMigrate(tt.args); code != tt.wantCode {
+				t.Errorf("expected exit code %d, got %d", tt.wantCode, code)
+			}
+			slog.SetDefault(origLogger)
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ri_missing.db")); !os.IsNotExist(err) {
+		t.Errorf("expected no database to be created, got %v", err)
+	}
+}
+
+func TestGetDatabaseOutdatedSchema(t *testing.T) {
+	origLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(origLogger) })
+
+	dir := t.TempDir()
+	createLegacyDatabase(t, filepath.Join(dir, "ri_test_legacy.db"))
+	restoreConfig(t)
+	dbPath = dir
+	t.Cleanup(func() { evictDatabase("ri_test_legacy") })
+
+	if _, _, _, err := getDatabase("ri_test_legacy"); err == nil || !strings.Contains(err.Error(), "migrate") {
+		t.Errorf("expected an outdated schema error, got %v", err)
+	}
+
+	// Outdated databases do not stop the server (their ingest units migrate
+	// them), and are served once migrated
+	if err := validateDatabases(); err != nil {
+		t.Errorf("expected outdated databases to pass validation, got %v", err)
+	}
+	if code := runMigrate([]string{filepath.Join(dir, "ri_test_legacy.db")}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	slog.SetDefault(origLogger)
+	_, _, release, err := getDatabase("ri_test_legacy")
+	if err != nil {
+		t.Fatalf("expected the migrated database to be served, got %v", err)
+	}
+	release()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Rate Index database names, e.g. ri_apow_supply_0 (token, mode, pool)
+	riNameRegex = regexp.MustCompile(`^ri_([a-z]+)_(supply|borrow)_([0-9]+)$`)
+	// Rate Tracker database names, e.g. rt_apow_xpow_0 (source, target, oracle)
+	rtNameRegex = regexp.MustCompile(`^rt_([a-z]+)_([a-z]+)_([0-9]+)$`)
+)
+
+// DatabaseName represents the components encoded in a database name
+type DatabaseName struct {
+	Kind   string `json:"kind"`             // "ri" or "rt"
+	Token  string `json:"token,omitempty"`  // ri_: e.g. APOW
+	Mode   string `json:"mode,omitempty"`   // ri_: supply or borrow
+	Pool   string `json:"pool,omitempty"`   // ri_: e.g. P000
+	Source string `json:"source,omitempty"` // rt_: e.g. APOW
+	Target string `json:"target,omitempty"` // rt_: e.g. XPOW
+	Oracle string `json:"oracle,omitempty"` // rt_: e.g. T000
+}
+
+// parseDatabaseName parses ri_{token}_{supply|borrow}_{pool} and
+// rt_{source}_{target}_{oracle} database names (without .db extension)
+func parseDatabaseName(dbName string) (DatabaseName, error) {
+	if m := riNameRegex.FindStringSubmatch(dbName); m != nil {
+		return DatabaseName{
+			Kind:  "ri",
+			Token: strings.ToUpper(m[1]),
+			Mode:  m[2],
+			Pool:  "P" + padNumber(m[3]),
+		}, nil
+	}
+	if m := rtNameRegex.FindStringSubmatch(dbName); m != nil {
+		return DatabaseName{
+			Kind:   "rt",
+			Source: strings.ToUpper(m[1]),
+			Target: strings.ToUpper(m[2]),
+			Oracle: "T" + padNumber(m[3]),
+		}, nil
+	}
+	return DatabaseName{}, fmt.Errorf("invalid database name format: %s", dbName)
+}
+
+// padNumber zero-pads a decimal number to three digits (e.g. 7 -> 007)
+func padNumber(value string) string {
+	n, _ := strconv.Atoi(value)
+	return fmt.Sprintf("%03d", n)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseDatabaseName(t *testing.T) {
+	tests := []struct {
+		dbName      string
+		expected    DatabaseName
+		expectError bool
+	}{
+		{"ri_apow_supply_0", DatabaseName{Kind: "ri", Token: "APOW", Mode: "supply", Pool: "P000"}, false},
+		{"ri_usdt_borrow_6", DatabaseName{Kind: "ri", Token: "USDT", Mode: "borrow", Pool: "P006"}, false},
+		{"ri_xpow_supply_12", DatabaseName{Kind: "ri", Token: "XPOW", Mode: "supply", Pool: "P012"}, false},
+		{"rt_apow_xpow_0", DatabaseName{Kind: "rt", Source: "APOW", Target: "XPOW", Oracle: "T000"}, false},
+		{"rt_usdc_apow_2", DatabaseName{Kind: "rt", Source: "USDC", Target: "APOW", Oracle: "T002"}, false},
+		{"ri_apow_lend_0", DatabaseName{}, true},
+		{"ri_apow_supply", DatabaseName{}, true},
+		{"rt_apow_0", DatabaseName{}, true},
+		{"RI_apow_supply_0", DatabaseName{}, true},
+		{"ri_test_db", DatabaseName{}, true},
+		{"", DatabaseName{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbName, func(t *testing.T) {
+			name, err := parseDatabaseName(tt.dbName)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got %+v", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// openAPIVersion is the OpenAPI version of the generated spec
+const openAPIVersion = "3.1.0"
+
+// handleOpenAPI serves the OpenAPI spec generated from the route registry
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(openAPISpec())
+}
+
+// openAPISpec builds an OpenAPI document from endpointRoutes and queryParams
+func openAPISpec() map[string]interface{} {
+	schemas := make(map[string]interface{})
+
+	// Static routes (see main)
+	object := map[string]interface{}{"type": "object"}
+	readiness := schemaOf(reflect.ValueOf(ReadinessReport{}), schemas)
+	paths := map[string]interface{}{
+		"/":             staticOperation("root", "API information and endpoint overview", object),
+		"/health":       staticOperation("health", "Health check", object),
+		"/ready":        staticOperation("ready", "Readiness and data freshness of all databases", readiness),
+		"/openapi.json": staticOperation("openapi", "OpenAPI specification of this API", object),
+		"/databases.json": staticOperation("databases", "Available databases with row counts and ranges",
+			schemaOf(reflect.ValueOf([]DatabaseInfo{}), schemas)),
+	}
+
+	// Readiness fails with the same report
+	ready := paths["/ready"].(map[string]interface{})["get"].(map[string]interface{})
+	ready["responses"].(map[string]interface{})["503"] = map[string]interface{}{
+		"description": "Unavailable or stale databases",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": readiness},
+		},
+	}
+
+	// Dynamic API routes from endpointRoutes map
+	for suffix, config := range endpointRoutes {
+		paths["/{dbName}"+suffix] = routeOperation(suffix, config, schemas)
+	}
+
+	// Errors are all reported as ErrorResponse
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaOf(reflect.ValueOf(ErrorResponse{}), schemas),
+				},
+			},
+		}
+	}
+	responses := map[string]interface{}{
+		"BadRequest":      errorResponse("Invalid database name or query parameter"),
+		"NotFound":        errorResponse("No data available"),
+		"InternalError":   errorResponse("Query failed or data processing error"),
+		"Unavailable":     errorResponse("Database not available"),
+		"Timeout":         errorResponse("Query timed out"),
+		"TooManyRequests": errorResponse("Rate limit exceeded (see Retry-After)"),
+		"Unauthorized":    errorResponse("Invalid API key"),
+		"Forbidden":       errorResponse("Route not allowed for API key"),
+	}
+
+	spec := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       apiTitle,
+			"description": apiDescription,
+			"version":     apiVersion,
+			"license": map[string]string{
+				"name": "GPL-3.0",
+				"url":  "https://www.gnu.org/licenses/gpl-3.0.en.html",
+			},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":   schemas,
+			"responses": responses,
+		},
+	}
+
+	// Optional API keys (anonymous access remains allowed)
+	if apiKeysPath != "" {
+		spec["components"].(map[string]interface{})["securitySchemes"] = map[string]interface{}{
+			"ApiKeyHeader": map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			"ApiKeyQuery":  map[string]string{"type": "apiKey", "in": "query", "name": "api_key"},
+		}
+		spec["security"] = []map[string][]string{{}, {"ApiKeyHeader": {}}, {"ApiKeyQuery": {}}}
+	}
+	return spec
+}
+
+// staticOperation describes a static GET route as an OpenAPI path item
+func staticOperation(id, summary string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": id,
+			"summary":     summary,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": summary,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": schema},
+					},
+				},
+			},
+		},
+	}
+}
+
+// routeOperation describes a registered API endpoint as an OpenAPI path item
+func routeOperation(suffix string, config *RouteConfig, schemas map[string]interface{}) map[string]interface{} {
+	// Database name with its required prefix (example taken from the route's example path)
+	dbParam := map[string]interface{}{
+		"name":        "dbName",
+		"in":          "path",
+		"required":    true,
+		"description": "Database name (without .db extension)",
+		"schema":      map[string]interface{}{"type": "string", "pattern": prefixPattern(config)},
+	}
+	if parts := strings.SplitN(config.Example, "/", 3); len(parts) == 3 {
+		dbParam["example"] = parts[1]
+	}
+	parameters := []interface{}{dbParam}
+
+	for _, param := range config.QueryParams {
+		paramConfig := queryParams[param]
+		parameters = append(parameters, map[string]interface{}{
+			"name":        param,
+			"in":          "query",
+			"required":    !paramConfig.Optional,
+			"description": "Format: " + paramConfig.Format,
+			"schema":      paramConfig.Schema,
+		})
+	}
+
+	// Only handlers reading include_removed document it
+	if config.Handler == nil || config.IncludeRemoved {
+		parameters = append(parameters, map[string]interface{}{
+			"name":        "include_removed",
+			"in":          "query",
+			"required":    false,
+			"description": "Set to 1 to include logs removed by chain reorgs (for debugging)",
+			"schema":      map[string]interface{}{"type": "string", "enum": []string{"0", "1"}},
+		})
+	}
+
+	responses := map[string]interface{}{
+		"400": map[string]string{"$ref": "#/components/responses/BadRequest"},
+		"500": map[string]string{"$ref": "#/components/responses/InternalError"},
+		"429": map[string]string{"$ref": "#/components/responses/TooManyRequests"},
+		"503": map[string]string{"$ref": "#/components/responses/Unavailable"},
+		"504": map[string]string{"$ref": "#/components/responses/Timeout"},
+	}
+	if apiKeysPath != "" {
+		responses["401"] = map[string]string{"$ref": "#/components/responses/Unauthorized"}
+		responses["403"] = map[string]string{"$ref": "#/components/responses/Forbidden"}
+	}
+	schema := responseSchema(config, schemas)
+
+	mediaType := config.MediaType
+	switch {
+	case mediaType == "text/event-stream":
+		// Events are sent as text; their data follows the response schema
+		parameters = append(parameters, map[string]interface{}{
+			"name":        "Last-Event-ID",
+			"in":          "header",
+			"required":    false,
+			"description": "Event id to resume after",
+			"schema":      map[string]interface{}{"type": "integer", "minimum": 0},
+		})
+		schema = map[string]interface{}{"type": "string", "x-event-data": schema}
+	case config.Handler == nil:
+		// Default handler: optional envelope, conditional requests and 404
+		if config.Response != nil && reflect.TypeOf(config.Response).Kind() == reflect.Slice {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        "envelope",
+				"in":          "query",
+				"required":    false,
+				"description": "Set to 1 to wrap results with their range and truncation",
+				"schema":      map[string]interface{}{"type": "string", "enum": []string{"0", "1"}},
+			})
+			envelope := Envelope{Results: config.Response}
+			schema = map[string]interface{}{"oneOf": []interface{}{
+				schema, schemaOf(reflect.ValueOf(envelope), schemas),
+			}}
+		}
+		responses["304"] = map[string]string{"description": "Not modified"}
+		responses["404"] = map[string]string{"$ref": "#/components/responses/NotFound"}
+	default:
+		responses["304"] = map[string]string{"description": "Not modified"}
+	}
+	if mediaType == "" {
+		mediaType = "application/json"
+	}
+
+	responses["200"] = map[string]interface{}{
+		"description": config.Description,
+		"content": map[string]interface{}{
+			mediaType: map[string]interface{}{"schema": schema},
+		},
+	}
+
+	return map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": strings.TrimSuffix(strings.TrimPrefix(suffix, "/"), ".json"),
+			"summary":     config.Description,
+			"parameters":  parameters,
+			"responses":   responses,
+		},
+	}
+}
+
+// prefixPattern returns the dbName pattern of a route (and its variants)
+func prefixPattern(config *RouteConfig) string {
+	var prefixes []string
+	for _, variant := range append([]*RouteConfig{config}, config.Variants...) {
+		if variant.DBPrefix != "" {
+			prefixes = append(prefixes, regexp.QuoteMeta(variant.DBPrefix))
+		}
+	}
+	if len(prefixes) == 1 {
+		return "^" + prefixes[0]
+	}
+	return "^(" + strings.Join(prefixes, "|") + ")"
+}
+
+// responseSchema returns the response schema of a route (one of its variants)
+func responseSchema(config *RouteConfig, schemas map[string]interface{}) map[string]interface{} {
+	var alternatives []interface{}
+	for _, variant := range append([]*RouteConfig{config}, config.Variants...) {
+		if variant.Response != nil {
+			alternatives = append(alternatives, schemaOf(reflect.ValueOf(variant.Response), schemas))
+		}
+	}
+
+	switch len(alternatives) {
+	case 0:
+		return map[string]interface{}{}
+	case 1:
+		return alternatives[0].(map[string]interface{})
+	}
+	return map[string]interface{}{"oneOf": alternatives}
+}
+
+// schemaOf derives a JSON Schema from a sample value; named structs are added
+// to schemas and referenced, unless they wrap interface{} fields (such as
+// Envelope), whose schema depends on the sample's content
+func schemaOf(v reflect.Value, schemas map[string]interface{}) map[string]interface{} {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return map[string]interface{}{}
+		}
+		return schemaOf(v.Elem(), schemas)
+	case reflect.Ptr:
+		elem := reflect.Zero(v.Type().Elem())
+		if !v.IsNil() {
+			elem = v.Elem()
+		}
+		return nullable(schemaOf(elem, schemas))
+	case reflect.Slice:
+		elem := reflect.Zero(v.Type().Elem())
+		if v.Len() > 0 {
+			elem = v.Index(0)
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(elem, schemas)}
+	case reflect.Struct:
+		if hasInterfaceField(v.Type()) {
+			return objectSchema(v, schemas)
+		}
+		name := v.Type().Name()
+		if _, exists := schemas[name]; !exists {
+			schemas[name] = nil // reserve name for recursive types
+			schemas[name] = objectSchema(v, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Map:
+		elem := reflect.Zero(v.Type().Elem())
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(elem, schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// objectSchema derives an object schema from the JSON fields of a struct
+// (embedded structs are flattened, omitempty fields are not required)
+func objectSchema(v reflect.Value, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+
+	var addFields func(v reflect.Value)
+	addFields = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+				addFields(v.Field(i))
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(v.Field(i), schemas)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(v)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// hasInterfaceField reports whether a struct has interface{} fields
+func hasInterfaceField(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Interface {
+			return true
+		}
+	}
+	return false
+}
+
+// nullable allows null in addition to the given schema
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+		return schema
+	}
+	return map[string]interface{}{"oneOf": []interface{}{schema, map[string]string{"type": "null"}}}
+}
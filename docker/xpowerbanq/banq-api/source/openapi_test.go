@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleOpenAPI(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	handleOpenAPI(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+
+	var spec map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("failed to parse spec: %v", err)
+	}
+	if spec["openapi"] != "3.1.0" {
+		t.Errorf("expected openapi 3.1.0, got %v", spec["openapi"])
+	}
+
+	// Every registered route is documented
+	paths := spec["paths"].(map[string]interface{})
+	for suffix := range endpointRoutes {
+		if _, ok := paths["/{dbName}"+suffix]; !ok {
+			t.Errorf("expected path /{dbName}%s in spec", suffix)
+		}
+	}
+
+	// Every reference resolves to a component
+	components := spec["components"].(map[string]interface{})
+	var checkRefs func(node interface{})
+	checkRefs = func(node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				kind, _ := components[parts[0]].(map[string]interface{})
+				if len(parts) != 2 || kind[parts[1]] == nil {
+					t.Errorf("unresolved reference %s", ref)
+				}
+			}
+			for _, value := range node {
+				checkRefs(value)
+			}
+		case []interface{}:
+			for _, value := range node {
+				checkRefs(value)
+			}
+		}
+	}
+	checkRefs(spec)
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	spec := openAPISpec()
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	for _, name := range []string{"ErrorResponse", "DailyAverage", "DailyOHLC", "LatestRate", "LatestQuote", "RateEvent", "QuoteEvent", "DatabaseInfo"} {
+		if schemas[name] == nil {
+			t.Errorf("expected schema %s", name)
+		}
+	}
+
+	// Fields map to JSON names and types
+	average := schemas["DailyAverage"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"avg_util": map[string]interface{}{"type": "number"},
+		"day":      map[string]interface{}{"type": "string"},
+		"n":        map[string]interface{}{"type": "integer"},
+	}
+	if !reflect.DeepEqual(average["properties"], expected) {
+		t.Errorf("unexpected DailyAverage properties: %v", average["properties"])
+	}
+	if !reflect.DeepEqual(average["required"], []string{"avg_util", "day", "n"}) {
+		t.Errorf("unexpected DailyAverage required: %v", average["required"])
+	}
+
+	// Pointers are nullable
+	ohlc := schemas["DailyOHLC"].(map[string]interface{})["properties"].(map[string]interface{})
+	if open := ohlc["open"].(map[string]interface{}); !reflect.DeepEqual(open["type"], []string{"number", "null"}) {
+		t.Errorf("expected nullable open, got %v", open)
+	}
+
+	// Embedded structs are flattened, omitempty fields are optional
+	event := schemas["RateEvent"].(map[string]interface{})
+	if _, ok := event["properties"].(map[string]interface{})["block_number"]; !ok {
+		t.Errorf("expected embedded block_number in RateEvent")
+	}
+	info := schemas["DatabaseInfo"].(map[string]interface{})
+	for _, name := range info["required"].([]string) {
+		if name == "token" {
+			t.Errorf("expected omitempty token to be optional")
+		}
+	}
+}
+
+func TestOpenAPIParameters(t *testing.T) {
+	paths := openAPISpec()["paths"].(map[string]interface{})
+
+	parameters := func(path string) map[string]map[string]interface{} {
+		operation := paths[path].(map[string]interface{})["get"].(map[string]interface{})
+		params := make(map[string]map[string]interface{})
+		for _, param := range operation["parameters"].([]interface{}) {
+			param := param.(map[string]interface{})
+			params[param["name"].(string)] = param
+		}
+		return params
+	}
+
+	tests := []struct {
+		path          string
+		dbPattern     string
+		required      []string
+		optional      []string
+		expectMissing []string
+	}{
+		{"/{dbName}/daily_average.json", "^ri_", []string{"lhs", "rhs"}, []string{"envelope", "include_removed"}, nil},
+		{"/{dbName}/daily_ohlc.json", "^rt_", []string{"lhs", "rhs"}, []string{"envelope", "include_removed"}, nil},
+		{"/{dbName}/ohlc.json", "^rt_", []string{"lhs", "rhs", "interval"}, []string{"envelope", "include_removed"}, nil},
+		{"/{dbName}/latest.json", "^(ri_|rt_)", nil, []string{"include_removed"}, []string{"envelope"}},
+		{"/{dbName}/events.json", "^(ri_|rt_)", nil, []string{"cursor", "limit", "include_removed"}, []string{"envelope"}},
+		{"/{dbName}/stream", "^(ri_|rt_)", nil, []string{"Last-Event-ID", "include_removed"}, []string{"envelope"}},
+		{"/{dbName}/coverage.json", "^(ri_|rt_)", nil, nil, []string{"envelope", "include_removed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			params := parameters(tt.path)
+
+			dbName := params["dbName"]
+			if dbName["in"] != "path" || dbName["schema"].(map[string]interface{})["pattern"] != tt.dbPattern {
+				t.Errorf("expected dbName pattern %s, got %v", tt.dbPattern, dbName)
+			}
+			for _, name := range tt.required {
+				if params[name] == nil || params[name]["required"] != true {
+					t.Errorf("expected required parameter %s", name)
+				}
+			}
+			for _, name := range tt.optional {
+				if params[name] == nil || params[name]["required"] != false {
+					t.Errorf("expected optional parameter %s", name)
+				}
+			}
+			for _, name := range tt.expectMissing {
+				if params[name] != nil {
+					t.Errorf("unexpected parameter %s", name)
+				}
+			}
+		})
+	}
+
+	// Parameter schemas come from queryParams
+	rhs := parameters("/{dbName}/daily_average.json")["rhs"]
+	if rhs["schema"].(map[string]interface{})["format"] != "date" {
+		t.Errorf("expected rhs format date, got %v", rhs["schema"])
+	}
+	lhs := parameters("/{dbName}/ohlc.json")["lhs"]
+	if lhs["schema"].(map[string]interface{})["pattern"] != dateTimeRegex.String() {
+		t.Errorf("expected lhs pattern of dates and times, got %v", lhs["schema"])
+	}
+}
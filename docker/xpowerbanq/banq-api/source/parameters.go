@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -14,8 +17,33 @@ func dbPrefixed(dbName string, expectedPrefix string) error {
 	return nil
 }
 
+// routeVariant selects the route config (or variant) matching the database name prefix
+func routeVariant(dbName string, config *RouteConfig) (*RouteConfig, error) {
+	if len(config.Variants) == 0 {
+		return config, dbPrefixed(dbName, config.DBPrefix)
+	}
+
+	prefixes := make([]string, 0, len(config.Variants))
+	for _, variant := range config.Variants {
+		if dbPrefixed(dbName, variant.DBPrefix) == nil {
+			return variant, nil
+		}
+		prefixes = append(prefixes, variant.DBPrefix)
+	}
+	return nil, fmt.Errorf("Invalid database name. Must start with %s", strings.Join(prefixes, " or "))
+}
+
+// paramFrom parses a query parameter using its configured parser
+func paramFrom(r *http.Request, paramName string) (interface{}, error) {
+	config, exists := queryParams[paramName]
+	if !exists {
+		return nil, fmt.Errorf("Unsupported parameter: %s", paramName)
+	}
+	return config.Parse(r, paramName)
+}
+
 // dateFrom parses and validates a date query parameter (lhs or rhs)
-func dateFrom(r *http.Request, paramName string) (string, error) {
+func dateFrom(r *http.Request, paramName string) (interface{}, error) {
 	value := r.URL.Query().Get(paramName)
 	if value == "" {
 		return "", fmt.Errorf("Missing required parameter: %s", paramName)
@@ -27,3 +55,164 @@ func dateFrom(r *http.Request, paramName string) (string, error) {
 
 	return value, nil
 }
+
+// dateTimeFrom parses and validates a date (YYYY-MM-DD) or time
+// (YYYY-MM-DD HH:MM:SS) query parameter
+func dateTimeFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return "", fmt.Errorf("Missing required parameter: %s", paramName)
+	}
+
+	if !dateTimeRegex.MatchString(value) {
+		return "", fmt.Errorf("Invalid %s date format. Use YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", paramName)
+	}
+
+	return value, nil
+}
+
+// intFrom parses and validates an integer query parameter
+func intFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return nil, fmt.Errorf("Missing required parameter: %s", paramName)
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s. Use an integer", paramName)
+	}
+
+	return n, nil
+}
+
+// numberFrom parses and validates a (finite) number query parameter
+func numberFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return nil, fmt.Errorf("Missing required parameter: %s", paramName)
+	}
+
+	x, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+		return nil, fmt.Errorf("Invalid %s. Use a number", paramName)
+	}
+
+	return x, nil
+}
+
+// stringFrom parses and validates a string query parameter (up to 256 bytes)
+func stringFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return nil, fmt.Errorf("Missing required parameter: %s", paramName)
+	}
+	if len(value) > 256 {
+		return nil, fmt.Errorf("Invalid %s. Use at most 256 characters", paramName)
+	}
+
+	return value, nil
+}
+
+// intervalFrom parses and validates a candle interval query parameter into
+// seconds, where zero denotes a calendar month (1M)
+func intervalFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return nil, fmt.Errorf("Missing required parameter: %s", paramName)
+	}
+
+	match := intervalRegex.FindStringSubmatch(value)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid %s format. Use e.g. 1h, 4h, 1d, 1w or 1M", paramName)
+	}
+	if match[1] == "" {
+		return int64(0), nil
+	}
+
+	n, _ := strconv.ParseInt(match[1], 10, 64)
+	switch match[2] {
+	case "h":
+		return n * 3600, nil
+	case "d":
+		return n * 86400, nil
+	default:
+		return n * 604800, nil
+	}
+}
+
+// eventCursor is the (block number, log index) position of a raw event
+type eventCursor struct {
+	Block int64
+	Index int64
+}
+
+// String encodes the cursor as an opaque URL-safe token
+func (c eventCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Block, c.Index)))
+}
+
+// cursorFrom parses and validates an optional opaque cursor query parameter;
+// a missing cursor starts before the first event
+func cursorFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return eventCursor{Block: -1, Index: -1}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s", paramName)
+	}
+	var cursor eventCursor
+	if n, err := fmt.Sscanf(string(data), "%d:%d", &cursor.Block, &cursor.Index); err != nil || n != 2 ||
+		cursor.String() != value {
+		return nil, fmt.Errorf("Invalid %s", paramName)
+	}
+
+	return cursor, nil
+}
+
+// limitFrom parses and validates an optional page size query parameter,
+// capped by the row limit (default: the row limit)
+func limitFrom(r *http.Request, paramName string) (interface{}, error) {
+	value := r.URL.Query().Get(paramName)
+	if value == "" {
+		return rowLimit(r), nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return nil, fmt.Errorf("Invalid %s. Use a positive integer", paramName)
+	}
+	if limit > rowLimit(r) {
+		limit = rowLimit(r)
+	}
+
+	return limit, nil
+}
+
+// envelopeFrom parses the optional envelope query parameter (0 or 1)
+func envelopeFrom(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("envelope") {
+	case "", "0":
+		return false, nil
+	case "1":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid envelope. Use 0 or 1")
+	}
+}
+
+// includeRemovedFrom parses the optional include_removed query parameter (0
+// or 1); logs removed by chain reorgs are excluded unless it is 1
+func includeRemovedFrom(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("include_removed") {
+	case "", "0":
+		return false, nil
+	case "1":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid include_removed. Use 0 or 1")
+	}
+}
@@ -200,3 +200,236 @@ func TestValidateDate(t *testing.T) {
 		})
 	}
 }
+
+func TestIntervalFrom(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    int64
+		expectError bool
+	}{
+		{"one hour", "1h", 3600, false},
+		{"four hours", "4h", 14400, false},
+		{"one day", "1d", 86400, false},
+		{"one week", "1w", 604800, false},
+		{"one month", "1M", 0, false},
+		{"missing parameter", "", 0, true},
+		{"zero interval", "0h", 0, true},
+		{"minutes unsupported", "15m", 0, true},
+		{"multiple months unsupported", "3M", 0, true},
+		{"too many digits", "100h", 0, true},
+		{"SQL injection", "1h' OR 1=1--", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.value != "" {
+				query.Set("interval", tt.value)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			value, err := intervalFrom(req, "interval")
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected interval %d, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestParamFrom(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?lhs=2025-11-15&interval=1d", nil)
+
+	if value, err := paramFrom(req, "lhs"); err != nil || value != "2025-11-15" {
+		t.Errorf("expected lhs 2025-11-15, got %v (%v)", value, err)
+	}
+	if value, err := paramFrom(req, "interval"); err != nil || value != int64(86400) {
+		t.Errorf("expected interval 86400, got %v (%v)", value, err)
+	}
+	if _, err := paramFrom(req, "unknown"); err == nil {
+		t.Errorf("expected error for unsupported parameter")
+	}
+}
+
+func TestRouteVariant(t *testing.T) {
+	config := endpointRoutes["/events.json"]
+
+	tests := []struct {
+		dbName         string
+		expectedPrefix string
+		expectError    bool
+	}{
+		{"ri_apow_supply_0", "ri_", false},
+		{"rt_apow_xpow_0", "rt_", false},
+		{"xx_apow_xpow_0", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbName, func(t *testing.T) {
+			variant, err := routeVariant(tt.dbName, config)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if variant.DBPrefix != tt.expectedPrefix {
+				t.Errorf("expected variant %s, got %s", tt.expectedPrefix, variant.DBPrefix)
+			}
+		})
+	}
+
+	// Routes without variants fall back to their own prefix
+	if variant, err := routeVariant("ri_apow_supply_0", endpointRoutes["/daily_average.json"]); err != nil ||
+		variant != endpointRoutes["/daily_average.json"] {
+		t.Errorf("expected route config itself, got %v (%v)", variant, err)
+	}
+}
+
+func TestCursorFrom(t *testing.T) {
+	valid := eventCursor{Block: 123456, Index: 7}.String()
+
+	tests := []struct {
+		name        string
+		value       string
+		expected    eventCursor
+		expectError bool
+	}{
+		{"missing cursor", "", eventCursor{Block: -1, Index: -1}, false},
+		{"valid cursor", valid, eventCursor{Block: 123456, Index: 7}, false},
+		{"not base64", "!!!", eventCursor{}, true},
+		{"not a cursor", "aGVsbG8", eventCursor{}, true},
+		{"trailing garbage", valid + "AA", eventCursor{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.value != "" {
+				query.Set("cursor", tt.value)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			value, err := cursorFrom(req, "cursor")
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected cursor %+v, got %+v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestLimitFrom(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    int
+		expectError bool
+	}{
+		{"missing limit", "", maxRows, false},
+		{"valid limit", "10", 10, false},
+		{"capped limit", "100000", maxRows, false},
+		{"zero limit", "0", 0, true},
+		{"negative limit", "-1", 0, true},
+		{"not a number", "ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.value != "" {
+				query.Set("limit", tt.value)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+			value, err := limitFrom(req, "limit")
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected limit %d, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestEnvelopeFrom(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    bool
+		expectError bool
+	}{
+		{"", false, false},
+		{"0", false, false},
+		{"1", true, false},
+		{"true", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run("envelope="+tt.value, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?envelope="+tt.value, nil)
+			envelope, err := envelopeFrom(req)
+
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if envelope != tt.expected {
+				t.Errorf("expected envelope %v, got %v", tt.expected, envelope)
+			}
+		})
+	}
+}
+
+func TestIncludeRemovedFrom(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    bool
+		expectError bool
+	}{
+		{"", false, false},
+		{"0", false, false},
+		{"1", true, false},
+		{"true", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run("include_removed="+tt.value, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?include_removed="+tt.value, nil)
+			includeRemoved, err := includeRemovedFrom(req)
+
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if includeRemoved != tt.expected {
+				t.Errorf("expected include_removed %v, got %v", tt.expected, includeRemoved)
+			}
+		})
+	}
+}
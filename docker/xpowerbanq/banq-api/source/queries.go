@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Declarative query file names (the endpoint name) and parameter names
+	queryNameRegex  = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	queryParamRegex = regexp.MustCompile(`^([a-z][a-z0-9_]*)(?::([a-z]+))?$`)
+
+	// Header lines of declarative queries, e.g. "-- prefix: ri_"
+	queryHeaderRegex = regexp.MustCompile(`^--\s*([a-z]+)\s*:\s*(.*?)\s*$`)
+
+	// Row limit placeholder required in declarative queries
+	queryLimitRegex = regexp.MustCompile(`:limit\b`)
+
+	// Parameter types of declarative queries
+	queryParamTypes = map[string]*ParamConfig{
+		"date": {Parse: dateFrom, Format: "YYYY-MM-DD",
+			Schema: map[string]interface{}{"type": "string", "format": "date"}},
+		"int": {Parse: intFrom, Format: "N",
+			Schema: map[string]interface{}{"type": "integer"}},
+		"number": {Parse: numberFrom, Format: "X.Y",
+			Schema: map[string]interface{}{"type": "number"}},
+		"string": {Parse: stringFrom, Format: "STRING",
+			Schema: map[string]interface{}{"type": "string", "maxLength": 256}},
+	}
+)
+
+// Row is a result row of a declarative query, encoded as a JSON object with
+// its columns in query order
+type Row struct {
+	columns []string
+	values  []interface{}
+}
+
+// MarshalJSON encodes the row as a JSON object
+func (row Row) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range row.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(row.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// date returns the day of a row from its day column, or else its time column
+// (the full time if it is YYYY-MM-DD HH:MM:SS, which lhs accepts), so that
+// enveloped results report where the next page starts
+func (row Row) date() string {
+	for _, column := range []string{"day", "time"} {
+		for i, name := range row.columns {
+			if value, ok := row.values[i].(string); ok && name == column {
+				if dateTimeRegex.MatchString(value) {
+					return value
+				}
+				return value[:min(len(value), 10)]
+			}
+		}
+	}
+	return ""
+}
+
+// scanRows scans the rows of a declarative query into Row results
+func scanRows(rows *sql.Rows) (interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns error: %w", err)
+	}
+
+	// Pre-allocate slice with maxRows capacity to avoid reallocations
+	results := make([]Row, 0, maxRows)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		for i, value := range values {
+			if data, ok := value.([]byte); ok {
+				values[i] = string(data)
+			}
+		}
+		results = append(results, Row{columns: columns, values: values})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// loadQueries registers the declarative queries (*.sql files) of a directory
+// as endpoints; the file name is the endpoint name (e.g. hourly_average.sql
+// serves /{dbName}/hourly_average.json) and a header of SQL comments
+// configures it:
+//
+//	-- description: Hourly average utilization rates
+//	-- prefix: ri_
+//	-- params: lhs, rhs, min_n:int
+//	-- example: /ri_apow_supply_0/hourly_average.json?lhs=2025-11-15&rhs=2025-12-15&min_n=2
+//	-- cache: 300
+//	-- timeout: 5s
+//	-- cost: 3
+//
+// Parameters are either predefined (e.g. lhs, rhs or interval) or declared
+// with a type (date, int, number or string), and bound by name (e.g. :lhs);
+// the row limit is bound to :limit, which every query must use
+func loadQueries(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return fmt.Errorf("failed to list query files: %v", err)
+	}
+	sort.Strings(paths)
+
+	// Parameters declared by query files (by name) with their types
+	declared := make(map[string]string)
+	for _, path := range paths {
+		suffix, config, err := parseQueryFile(path, declared)
+		if err != nil {
+			return err
+		}
+		endpointRoutes[suffix] = config
+		slog.Info("Registered query endpoint", "path", "/{dbName}"+suffix, "file", path)
+	}
+	return nil
+}
+
+// parseQueryFile parses a declarative query into its route suffix and config,
+// registering its declared parameters in queryParams
+func parseQueryFile(path string, declared map[string]string) (string, *RouteConfig, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".sql")
+	if !queryNameRegex.MatchString(name) {
+		return "", nil, fmt.Errorf("invalid query name %s: use lowercase letters, digits and underscores", path)
+	}
+	suffix := "/" + name + ".json"
+	if _, exists := endpointRoutes[suffix]; exists {
+		return "", nil, fmt.Errorf("query %s conflicts with existing endpoint %s", path, suffix)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read query file: %v", err)
+	}
+
+	// Parse header of leading comments (ignoring unrelated comments)
+	header := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if match := queryHeaderRegex.FindStringSubmatch(line); match != nil {
+			header[match[1]] = match[2]
+		}
+	}
+
+	config := &RouteConfig{
+		SQL:           string(data),
+		ResultScanner: scanRows,
+		Response:      []map[string]interface{}{},
+		Description:   header["description"],
+		Example:       header["example"],
+		NamedArgs:     true,
+	}
+	for key := range header {
+		switch key {
+		case "description", "example", "prefix", "params", "cache", "timeout", "cost":
+		default:
+			return "", nil, fmt.Errorf("unknown header %s in query %s", key, path)
+		}
+	}
+
+	if config.Description == "" {
+		return "", nil, fmt.Errorf("missing description header in query %s", path)
+	}
+	config.DBPrefix = header["prefix"]
+	if _, ok := catalogSQL[config.DBPrefix]; !ok {
+		return "", nil, fmt.Errorf("invalid prefix %q in query %s: use ri_ or rt_", config.DBPrefix, path)
+	}
+	if value, ok := header["cache"]; ok {
+		config.CacheMaxAge, err = strconv.Atoi(value)
+		if err != nil || config.CacheMaxAge < 1 {
+			return "", nil, fmt.Errorf("invalid cache %q in query %s: use a positive number of seconds", value, path)
+		}
+	}
+	if value, ok := header["timeout"]; ok {
+		config.Timeout, err = time.ParseDuration(value)
+		if err != nil || config.Timeout <= 0 {
+			return "", nil, fmt.Errorf("invalid timeout %q in query %s: use a positive duration, e.g. 5s", value, path)
+		}
+	}
+	if value, ok := header["cost"]; ok {
+		config.Cost, err = strconv.Atoi(value)
+		if err != nil || config.Cost < 1 {
+			return "", nil, fmt.Errorf("invalid cost %q in query %s: use a positive number of tokens", value, path)
+		}
+	}
+	if !queryLimitRegex.MatchString(config.SQL) {
+		return "", nil, fmt.Errorf("missing :limit in query %s", path)
+	}
+
+	// Resolve predefined and declare typed parameters
+	if params := header["params"]; params != "" {
+		for _, param := range strings.Split(params, ",") {
+			match := queryParamRegex.FindStringSubmatch(strings.TrimSpace(param))
+			if match == nil {
+				return "", nil, fmt.Errorf("invalid parameter %q in query %s", param, path)
+			}
+			paramName, paramType := match[1], match[2]
+			if paramName == "limit" || paramName == "cursor" || paramName == "envelope" || paramName == "include_removed" {
+				return "", nil, fmt.Errorf("reserved parameter %s in query %s", paramName, path)
+			}
+
+			switch {
+			case paramType == "":
+				if _, exists := queryParams[paramName]; !exists {
+					return "", nil, fmt.Errorf("unknown parameter %s in query %s: declare its type, e.g. %s:int", paramName, path, paramName)
+				}
+			case declared[paramName] == paramType:
+				// Declared by another query with the same type
+			case declared[paramName] != "":
+				return "", nil, fmt.Errorf("parameter %s in query %s conflicts with type %s", paramName, path, declared[paramName])
+			default:
+				typeConfig, ok := queryParamTypes[paramType]
+				if !ok {
+					return "", nil, fmt.Errorf("invalid type %s of parameter %s in query %s: use date, int, number or string", paramType, paramName, path)
+				}
+				if _, exists := queryParams[paramName]; exists {
+					return "", nil, fmt.Errorf("parameter %s in query %s is predefined: omit its type", paramName, path)
+				}
+				queryParams[paramName] = typeConfig
+				declared[paramName] = paramType
+			}
+			config.QueryParams = append(config.QueryParams, paramName)
+		}
+	}
+
+	return suffix, config, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// testHourlyQuery is a declarative query with predefined and typed parameters
+const testHourlyQuery = `-- description: Hourly average utilization rates
+-- prefix: ri_
+-- params: lhs, rhs, min_n:int
+-- example: /ri_apow_supply_0/hourly_average.json?lhs=2025-11-15&rhs=2025-12-15&min_n=1
+-- cache: 300
+SELECT strftime('%Y-%m-%d %H:00', stamp_iso) AS time,
+       AVG(util_e18) AS util, COUNT(*) AS n
+FROM riw_view
+WHERE stamp_iso >= :lhs AND stamp_iso < date(:rhs, '+1 day')
+GROUP BY 1 HAVING n >= :min_n
+ORDER BY 1
+LIMIT :limit;
+`
+
+// writeQueryFiles writes query files into a temporary directory
+func writeQueryFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// restoreQueries removes the endpoints and parameters registered by
+// declarative queries after the test
+func restoreQueries(t *testing.T) {
+	routes := make(map[string]bool, len(endpointRoutes))
+	for suffix := range endpointRoutes {
+		routes[suffix] = true
+	}
+	params := make(map[string]bool, len(queryParams))
+	for name := range queryParams {
+		params[name] = true
+	}
+
+	t.Cleanup(func() {
+		for suffix := range endpointRoutes {
+			if !routes[suffix] {
+				delete(endpointRoutes, suffix)
+			}
+		}
+		for name := range queryParams {
+			if !params[name] {
+				delete(queryParams, name)
+			}
+		}
+	})
+}
+
+func TestLoadQueries(t *testing.T) {
+	restoreQueries(t)
+
+	dir := writeQueryFiles(t, map[string]string{
+		"hourly_average.sql": testHourlyQuery,
+		"README.md":          "not a query",
+	})
+	if err := loadQueries(dir); err != nil {
+		t.Fatalf("loadQueries failed: %v", err)
+	}
+
+	config, ok := endpointRoutes["/hourly_average.json"]
+	if !ok {
+		t.Fatal("expected /hourly_average.json to be registered")
+	}
+	if config.DBPrefix != "ri_" || config.CacheMaxAge != 300 || !config.NamedArgs {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if config.Description != "Hourly average utilization rates" {
+		t.Errorf("unexpected description %q", config.Description)
+	}
+	if got := strings.Join(config.QueryParams, ","); got != "lhs,rhs,min_n" {
+		t.Errorf("unexpected parameters %s", got)
+	}
+	if _, ok := queryParams["min_n"]; !ok {
+		t.Error("expected min_n to be registered as query parameter")
+	}
+	if _, ok := endpointRoutes["/README.json"]; ok {
+		t.Error("expected non-SQL files to be ignored")
+	}
+}
+
+func TestLoadQueriesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"invalid name", map[string]string{"Hourly.sql": testHourlyQuery}, "invalid query name"},
+		{"existing endpoint", map[string]string{"daily_average.sql": testHourlyQuery}, "conflicts with existing endpoint"},
+		{"missing description", map[string]string{"q.sql": "-- prefix: ri_\nSELECT 1 LIMIT :limit;\n"}, "missing description"},
+		{"invalid prefix", map[string]string{"q.sql": "-- description: Q\n-- prefix: xx_\nSELECT 1 LIMIT :limit;\n"}, "invalid prefix"},
+		{"unknown header", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- ttl: 60\nSELECT 1 LIMIT :limit;\n"}, "unknown header ttl"},
+		{"invalid cache", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- cache: 0\nSELECT 1 LIMIT :limit;\n"}, "invalid cache"},
+		{"missing limit", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\nSELECT 1;\n"}, "missing :limit"},
+		{"reserved parameter", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- params: cursor:string\nSELECT 1 LIMIT :limit;\n"}, "reserved parameter cursor"},
+		{"unknown parameter", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- params: min_n\nSELECT 1 LIMIT :limit;\n"}, "unknown parameter min_n"},
+		{"invalid type", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- params: min_n:bool\nSELECT 1 LIMIT :limit;\n"}, "invalid type bool"},
+		{"predefined parameter", map[string]string{"q.sql": "-- description: Q\n-- prefix: ri_\n-- params: lhs:string\nSELECT 1 LIMIT :limit;\n"}, "is predefined"},
+		{"conflicting types", map[string]string{
+			"a.sql": "-- description: A\n-- prefix: ri_\n-- params: min_n:int\nSELECT 1 LIMIT :limit;\n",
+			"b.sql": "-- description: B\n-- prefix: rt_\n-- params: min_n:number\nSELECT 1 LIMIT :limit;\n",
+		}, "conflicts with type int"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreQueries(t)
+
+			err := loadQueries(writeQueryFiles(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestQueryEndpoint(t *testing.T) {
+	restoreQueries(t)
+
+	if err := loadQueries(writeQueryFiles(t, map[string]string{
+		"hourly_average.sql": testHourlyQuery,
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2025, 11, 15, 0, 0, 0, 0, time.UTC).Unix()
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_hourly",
+		testRateLine("r1", "400000000000000000", "1000000000000000000000000000", day+600, 100, 0),
+		testRateLine("r2", "600000000000000000", "1000000000000000000000000000", day+1200, 101, 0),
+		testRateLine("r3", "500000000000000000", "1000000000000000000000000000", day+4200, 102, 0),
+		testRateLine("r4", "500000000000000000", "1000000000000000000000000000", day+86400+600, 103, 0),
+	)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+	serve := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	t.Run("rows in column order", func(t *testing.T) {
+		rr := serve("/ri_test_hourly/hourly_average.json?lhs=2025-11-15&rhs=2025-11-15&min_n=2")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		expected := `[{"time":"2025-11-15 00:00","util":0.5,"n":2}]`
+		if body := strings.TrimSpace(rr.Body.String()); body != expected {
+			t.Errorf("expected %s, got %s", expected, body)
+		}
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		origMaxRows := maxRows
+		maxRows = 1
+		defer func() { maxRows = origMaxRows }()
+
+		rr := serve("/ri_test_hourly/hourly_average.json?lhs=2025-11-15&rhs=2025-11-16&min_n=1&envelope=1")
+		var envelope struct {
+			NextLHS   *string           `json:"next_lhs"`
+			Truncated bool              `json:"truncated"`
+			Results   []json.RawMessage `json:"results"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+		if !envelope.Truncated || len(envelope.Results) != 1 {
+			t.Errorf("expected one truncated result, got %+v", envelope)
+		}
+		if envelope.NextLHS == nil || *envelope.NextLHS != "2025-11-15" {
+			t.Errorf("expected next_lhs 2025-11-15, got %v", envelope.NextLHS)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, target := range []string{
+			"/ri_test_hourly/hourly_average.json?lhs=2025-11-15&rhs=2025-11-15",
+			"/ri_test_hourly/hourly_average.json?lhs=2025-11-15&rhs=2025-11-15&min_n=two",
+			"/rt_test_hourly/hourly_average.json?lhs=2025-11-15&rhs=2025-11-15&min_n=1",
+		} {
+			if rr := serve(target); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", target, rr.Code)
+			}
+		}
+	})
+}
+
+func TestTypedParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		parse    func(*http.Request, string) (interface{}, error)
+		value    string
+		expected interface{}
+		wantErr  bool
+	}{
+		{"int", intFrom, "42", int64(42), false},
+		{"int invalid", intFrom, "4.2", nil, true},
+		{"int missing", intFrom, "", nil, true},
+		{"number", numberFrom, "0.25", 0.25, false},
+		{"number invalid", numberFrom, "NaN", nil, true},
+		{"string", stringFrom, "apow", "apow", false},
+		{"string too long", stringFrom, strings.Repeat("x", 257), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?x="+tt.value, nil)
+			value, err := tt.parse(req, "x")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && value != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket is the rate limit budget of a client, refilled at rate tokens
+// per second up to burst tokens
+type tokenBucket struct {
+	tokens  float64
+	rate    float64
+	burst   int
+	updated time.Time
+}
+
+// rateLimiter is a token-bucket rate limiter keyed by client
+type rateLimiter struct {
+	mux     sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time // replaced by tests
+}
+
+// rateDecision is the outcome of taking tokens from a bucket
+type rateDecision struct {
+	allowed    bool
+	remaining  int           // whole tokens left
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the request could be allowed (if denied)
+}
+
+var (
+	// Rate limiter of API requests by client IP (reset by tests)
+	limiter = newRateLimiter()
+)
+
+// newRateLimiter creates a rate limiter without buckets
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// refill adds the tokens accrued since the bucket was last updated
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.burst), b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// take takes cost tokens from the bucket of key, which starts full and is
+// refilled at rate tokens per second up to burst tokens; costs above the
+// burst take the whole burst
+func (l *rateLimiter) take(key string, rate float64, burst, cost int) rateDecision {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.rate, b.burst = rate, burst
+	b.refill(now)
+
+	need := float64(min(cost, burst))
+	decision := rateDecision{allowed: b.tokens >= need}
+	if decision.allowed {
+		b.tokens -= need
+	} else {
+		decision.retryAfter = seconds((need - b.tokens) / rate)
+	}
+	decision.remaining = int(b.tokens)
+	decision.reset = seconds((float64(burst) - b.tokens) / rate)
+	return decision
+}
+
+// sweep drops the buckets that have been refilled completely (at most once
+// a minute), so idle clients do not accumulate
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// seconds converts fractional seconds into a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// routeCost returns the rate limit cost of a route (default: 1)
+func routeCost(config *RouteConfig) int {
+	if config.Cost > 0 {
+		return config.Cost
+	}
+	return 1
+}
+
+// rateLimitMiddleware limits requests per client IP (or API client), taking
+// cost tokens per request, and reports the remaining budget in RateLimit-*
+// headers; requests exceeding the budget are answered with 429 and Retry-After
+func rateLimitMiddleware(cost int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, rate, burst := clientRateLimit(r)
+			if rate <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision := limiter.take(key, rate, burst, cost)
+			window := ceilSeconds(seconds(float64(burst) / rate))
+			w.Header().Set("RateLimit-Policy", strconv.Itoa(burst)+";w="+strconv.FormatInt(window, 10))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.reset), 10))
+
+			if !decision.allowed {
+				w.Header().Set("Retry-After", strconv.FormatInt(max(ceilSeconds(decision.retryAfter), 1), 10))
+				writeError(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// resetLimiter replaces the rate limiter and its configuration for the
+// duration of a test, returning the limiter's clock
+func resetLimiter(t *testing.T, rate float64, burst int) *time.Time {
+	origLimiter, origRateLimit, origRateBurst := limiter, rateLimit, rateBurst
+	now := time.Date(2025, 11, 15, 0, 0, 0, 0, time.UTC)
+	limiter = newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	rateLimit, rateBurst = rate, burst
+	t.Cleanup(func() {
+		limiter, rateLimit, rateBurst = origLimiter, origRateLimit, origRateBurst
+	})
+	return &now
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	now := resetLimiter(t, 2, 4)
+
+	// Full bucket allows a burst
+	for i := 0; i < 4; i++ {
+		if d := limiter.take("a", 2, 4, 1); !d.allowed || d.remaining != 3-i {
+			t.Fatalf("request %d: unexpected decision %+v", i, d)
+		}
+	}
+	d := limiter.take("a", 2, 4, 1)
+	if d.allowed || d.retryAfter != 500*time.Millisecond || d.reset != 2*time.Second {
+		t.Errorf("expected denial with retry after 500ms, got %+v", d)
+	}
+
+	// Other clients have their own buckets
+	if d := limiter.take("b", 2, 4, 1); !d.allowed {
+		t.Errorf("expected other client to be allowed, got %+v", d)
+	}
+
+	// Tokens are refilled over time
+	*now = now.Add(time.Second)
+	if d := limiter.take("a", 2, 4, 2); !d.allowed || d.remaining != 0 {
+		t.Errorf("expected refilled tokens to allow cost 2, got %+v", d)
+	}
+
+	// Costs above the burst take the whole (full) bucket
+	*now = now.Add(time.Hour)
+	if d := limiter.take("a", 2, 4, 10); !d.allowed || d.remaining != 0 {
+		t.Errorf("expected cost above burst to take full bucket, got %+v", d)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	now := resetLimiter(t, 1, 10)
+
+	limiter.take("idle", 1, 10, 1)
+	*now = now.Add(2 * time.Minute)
+	limiter.take("active", 1, 10, 1)
+
+	if _, exists := limiter.buckets["idle"]; exists {
+		t.Error("expected refilled bucket of idle client to be swept")
+	}
+	if _, exists := limiter.buckets["active"]; !exists {
+		t.Error("expected bucket of active client to be kept")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	resetLimiter(t, 1, 5)
+
+	r := chi.NewRouter()
+	r.With(rateLimitMiddleware(2)).Get("/heavy", func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/heavy", nil)
+		req.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, remaining := range []string{"3", "1"} {
+		rr := serve("192.0.2.1:1234")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, rr.Code)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: RateLimit-Remaining = %s, want %s", i, got, remaining)
+		}
+	}
+
+	rr := serve("192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rr.Code)
+	}
+	expected := map[string]string{
+		"RateLimit-Policy":    "5;w=5",
+		"RateLimit-Limit":     "5",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "4",
+		"Retry-After":         "1",
+		"Content-Type":        "application/json",
+	}
+	for header, value := range expected {
+		if got := rr.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	// Clients behind a trusted proxy are told apart by X-Real-IP
+	req := httptest.NewRequest(http.MethodGet, "/heavy", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected other client behind proxy to be allowed, got %d", rr.Code)
+	}
+
+	// Disabled rate limit
+	rateLimit = 0
+	if rr := serve("192.0.2.1:1234"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected unlimited request without headers, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestRateLimitRouteCosts(t *testing.T) {
+	resetLimiter(t, 1, 5)
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	// OHLC candles take 5 tokens, exhausting the burst at once
+	req := httptest.NewRequest(http.MethodGet, "/rt_missing/ohlc.json?lhs=2025-11-15&rhs=2025-12-15&interval=4h", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if got := rr.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining after ohlc = %s, want 0", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/rt_missing/latest.json", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", rr.Code)
+	}
+}
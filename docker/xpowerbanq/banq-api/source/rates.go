@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	// secondsPerYear is the length of a (365 day) year used for annualizing
+	secondsPerYear = 365 * 86400
+	// compoundingPeriods is the number of (daily) compounding periods per year
+	compoundingPeriods = 365
+	// ratePrecision is the mantissa precision in bits of compounded rates
+	ratePrecision = 256
+)
+
+// annualize derives the APR and APY from the growth of an index (in ray
+// units, as decimal strings) over the given number of seconds. The APR is
+// exact, the APY compounds it daily; both are nil if undefined.
+func annualize(firstRay, lastRay string, seconds int64) (apr, apy *float64, err error) {
+	first, ok := new(big.Rat).SetString(firstRay)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid index: %q", firstRay)
+	}
+	last, ok := new(big.Rat).SetString(lastRay)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid index: %q", lastRay)
+	}
+	if first.Sign() <= 0 || seconds <= 0 {
+		return nil, nil, nil
+	}
+
+	// APR = (last/first - 1) * secondsPerYear/seconds
+	rate := new(big.Rat).Quo(last, first)
+	rate.Sub(rate, big.NewRat(1, 1))
+	rate.Mul(rate, big.NewRat(secondsPerYear, seconds))
+	aprValue, _ := rate.Float64()
+
+	// APY = (1 + APR/periods)^periods - 1
+	base := new(big.Rat).Quo(rate, big.NewRat(compoundingPeriods, 1))
+	base.Add(base, big.NewRat(1, 1))
+	growth := powFloat(new(big.Float).SetPrec(ratePrecision).SetRat(base), compoundingPeriods)
+	growth.Sub(growth, big.NewFloat(1))
+	apyValue, _ := growth.Float64()
+
+	return &aprValue, &apyValue, nil
+}
+
+// powFloat raises x to the non-negative integer power n by repeated squaring
+func powFloat(x *big.Float, n int) *big.Float {
+	result := new(big.Float).SetPrec(x.Prec()).SetInt64(1)
+	square := new(big.Float).Copy(x)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result.Mul(result, square)
+		}
+		square.Mul(square, square)
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnnualize(t *testing.T) {
+	tests := []struct {
+		name        string
+		first       string
+		last        string
+		seconds     int64
+		expectedAPR *float64
+		expectedAPY *float64
+		expectError bool
+	}{
+		{
+			name:        "one basis point per day",
+			first:       "1000000000000000000000000000",
+			last:        "1000100000000000000000000000",
+			seconds:     86400,
+			expectedAPR: ptr(0.0365),
+			expectedAPY: ptr(math.Pow(1.0001, 365) - 1),
+		},
+		{
+			name:        "one basis point per half day",
+			first:       "1000000000000000000000000000",
+			last:        "1000100000000000000000000000",
+			seconds:     43200,
+			expectedAPR: ptr(0.073),
+			expectedAPY: ptr(math.Pow(1.0002, 365) - 1),
+		},
+		{
+			name:        "no growth",
+			first:       "1234567890123456789012345678",
+			last:        "1234567890123456789012345678",
+			seconds:     3600,
+			expectedAPR: ptr(0.0),
+			expectedAPY: ptr(0.0),
+		},
+		{
+			name:        "beyond float64 precision",
+			first:       "1000000000000000000000000001",
+			last:        "1000000000000000000000000002",
+			seconds:     secondsPerYear,
+			expectedAPR: ptr(1e-27),
+			expectedAPY: ptr(1e-27),
+		},
+		{
+			name:    "single event",
+			first:   "1000000000000000000000000000",
+			last:    "1000000000000000000000000000",
+			seconds: 0,
+		},
+		{
+			name:    "zero index",
+			first:   "0",
+			last:    "1000000000000000000000000000",
+			seconds: 86400,
+		},
+		{
+			name:        "invalid index",
+			first:       "1e27n",
+			last:        "1000000000000000000000000000",
+			seconds:     86400,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apr, apy, err := annualize(tt.first, tt.last, tt.seconds)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertRate(t, "apr", apr, tt.expectedAPR)
+			assertRate(t, "apy", apy, tt.expectedAPY)
+		})
+	}
+}
+
+func assertRate(t *testing.T, name string, got, expected *float64) {
+	t.Helper()
+	if expected == nil || got == nil {
+		if expected != got {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+		return
+	}
+	if math.Abs(*got-*expected) > 1e-12*math.Max(1, math.Abs(*expected)) {
+		t.Errorf("%s: expected %v, got %v", name, *expected, *got)
+	}
+}
+
+func ptr(value float64) *float64 {
+	return &value
+}
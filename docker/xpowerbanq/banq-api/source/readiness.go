@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ReadinessReport represents the readiness of the service and its databases
+type ReadinessReport struct {
+	Status    string              `json:"status"` // "ok" or "unavailable"
+	Databases []DatabaseReadiness `json:"databases"`
+}
+
+// DatabaseReadiness represents the availability and data freshness of a database
+type DatabaseReadiness struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // "ok", "empty", "stale" or "unavailable"
+	LastTime *string `json:"last_time"`
+	Age      *int64  `json:"age"`     // seconds since last_time
+	MaxAge   int64   `json:"max_age"` // staleness threshold in seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+// handleReady serves the readiness of all databases: each is pinged and the
+// age of its newest record checked against the staleness threshold of its
+// prefix; any unavailable or stale database fails readiness with 503 (empty
+// ones only count as stale if their prefix has a threshold)
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	infos, err := listDatabases()
+	if err != nil {
+		requestLog(r).Error("Catalog error", "err", err)
+		writeError(w, "Catalog not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now()
+	report := ReadinessReport{Status: "ok", Databases: make([]DatabaseReadiness, 0, len(infos))}
+	for _, info := range infos {
+		readiness := databaseReadiness(r, info, now)
+		if readiness.Status == "stale" || readiness.Status == "unavailable" {
+			report.Status = "unavailable"
+		}
+		report.Databases = append(report.Databases, readiness)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Readiness checks should not be cached
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// databaseReadiness checks a database from its catalog entry
+func databaseReadiness(r *http.Request, info DatabaseInfo, now time.Time) DatabaseReadiness {
+	maxAge := maxStaleness[info.Kind+"_"]
+	readiness := DatabaseReadiness{
+		Name:     info.Name,
+		Status:   "ok",
+		LastTime: info.LastTime,
+		MaxAge:   int64(maxAge.Seconds()),
+	}
+
+	db, _, release, err := getDatabase(info.Name)
+	if err == nil {
+		err = db.PingContext(r.Context())
+		release()
+	}
+	if err != nil {
+		requestLog(r).Error("Database error", "db", info.Name, "err", err)
+		readiness.Status = "unavailable"
+		readiness.Error = "Database not available"
+		return readiness
+	}
+
+	// Databases without records are stale, unless the check is disabled
+	if info.LastTime == nil {
+		readiness.Status = "empty"
+		if maxAge > 0 {
+			readiness.Status = "stale"
+		}
+		return readiness
+	}
+	age := max(int64(now.Sub(parseStamp(*info.LastTime)).Seconds()), 0)
+	readiness.Age = &age
+	if maxAge > 0 && age > readiness.MaxAge {
+		readiness.Status = "stale"
+	}
+	return readiness
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveReady serves /ready and decodes its report
+func serveReady(t *testing.T) (int, ReadinessReport) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handleReady(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	var report ReadinessReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	return rr.Code, report
+}
+
+// readinessOf returns the readiness of a database in a report
+func readinessOf(t *testing.T, report ReadinessReport, dbName string) DatabaseReadiness {
+	t.Helper()
+	for _, readiness := range report.Databases {
+		if readiness.Name == dbName {
+			return readiness
+		}
+	}
+	t.Fatalf("database %s missing in report %+v", dbName, report)
+	return DatabaseReadiness{}
+}
+
+func TestHandleReady(t *testing.T) {
+	now := time.Now().Unix()
+	fresh := func(tempDir string) {
+		createTestDatabase(t, tempDir, "ri_ready_fresh_0",
+			testRateLine("r1", "500000000000000000", "1000000000000000000000000000", now-3600, 100, 0),
+		)
+	}
+
+	t.Run("fresh databases", func(t *testing.T) {
+		tempDir := t.TempDir()
+		fresh(tempDir)
+		createTestDatabase(t, tempDir, "rt_ready_fresh_0",
+			testQuoteLine("q1", "1000000000000000000", "2000000000000000000", now-600, 100, 0),
+		)
+
+		code, report := serveReady(t)
+		if code != http.StatusOK || report.Status != "ok" {
+			t.Fatalf("expected ready, got %d: %+v", code, report)
+		}
+		rt := readinessOf(t, report, "rt_ready_fresh_0")
+		if rt.Status != "ok" || rt.Age == nil || *rt.Age < 600 || rt.MaxAge != 7200 {
+			t.Errorf("unexpected readiness %+v", rt)
+		}
+	})
+
+	t.Run("stale database", func(t *testing.T) {
+		tempDir := t.TempDir()
+		fresh(tempDir)
+		createTestDatabase(t, tempDir, "rt_ready_stale_0",
+			testQuoteLine("q1", "1000000000000000000", "2000000000000000000", now-3*3600, 100, 0),
+		)
+
+		code, report := serveReady(t)
+		if code != http.StatusServiceUnavailable || report.Status != "unavailable" {
+			t.Fatalf("expected unavailable, got %d: %+v", code, report)
+		}
+		if rt := readinessOf(t, report, "rt_ready_stale_0"); rt.Status != "stale" {
+			t.Errorf("expected stale rt_ database, got %+v", rt)
+		}
+		if ri := readinessOf(t, report, "ri_ready_fresh_0"); ri.Status != "ok" {
+			t.Errorf("expected fresh ri_ database, got %+v", ri)
+		}
+	})
+
+	t.Run("empty database", func(t *testing.T) {
+		tempDir := t.TempDir()
+		createTestDatabase(t, tempDir, "rt_ready_empty_0")
+
+		code, report := serveReady(t)
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected status 503, got %d", code)
+		}
+		if rt := readinessOf(t, report, "rt_ready_empty_0"); rt.Status != "stale" || rt.LastTime != nil {
+			t.Errorf("expected stale empty database, got %+v", rt)
+		}
+	})
+
+	t.Run("disabled threshold", func(t *testing.T) {
+		origMaxStaleness := maxStaleness
+		maxStaleness = map[string]time.Duration{"ri_": 24 * time.Hour, "rt_": 0}
+		defer func() { maxStaleness = origMaxStaleness }()
+
+		tempDir := t.TempDir()
+		createTestDatabase(t, tempDir, "rt_ready_old_0",
+			testQuoteLine("q1", "1000000000000000000", "2000000000000000000", now-30*86400, 100, 0),
+		)
+
+		if code, report := serveReady(t); code != http.StatusOK {
+			t.Errorf("expected status 200, got %d: %+v", code, report)
+		}
+	})
+
+	t.Run("empty database with disabled threshold", func(t *testing.T) {
+		origMaxStaleness := maxStaleness
+		maxStaleness = map[string]time.Duration{"ri_": 24 * time.Hour, "rt_": 0}
+		defer func() { maxStaleness = origMaxStaleness }()
+
+		tempDir := t.TempDir()
+		createTestDatabase(t, tempDir, "rt_ready_empty_1")
+
+		code, report := serveReady(t)
+		if code != http.StatusOK || report.Status != "ok" {
+			t.Fatalf("expected ready, got %d: %+v", code, report)
+		}
+		if rt := readinessOf(t, report, "rt_ready_empty_1"); rt.Status != "empty" || rt.LastTime != nil {
+			t.Errorf("expected empty database, got %+v", rt)
+		}
+	})
+}
+
+func TestStalenessFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected map[string]time.Duration
+		wantErr  bool
+	}{
+		{"override one prefix", `{"rt_":"30m"}`,
+			map[string]time.Duration{"ri_": 24 * time.Hour, "rt_": 30 * time.Minute}, false},
+		{"disable", `{"ri_":"0s","rt_":"0s"}`,
+			map[string]time.Duration{"ri_": 0, "rt_": 0}, false},
+		{"invalid JSON", `rt_=2h`, nil, true},
+		{"invalid prefix", `{"xx_":"2h"}`, nil, true},
+		{"invalid duration", `{"rt_":"2 hours"}`, nil, true},
+		{"negative duration", `{"rt_":"-1h"}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stalenessFlag{thresholds: map[string]time.Duration{"ri_": 24 * time.Hour, "rt_": 2 * time.Hour}}
+			err := s.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for prefix, threshold := range tt.expected {
+				if s.thresholds[prefix] != threshold {
+					t.Errorf("threshold of %s = %v, want %v", prefix, s.thresholds[prefix], threshold)
+				}
+			}
+		})
+	}
+
+	s := stalenessFlag{thresholds: map[string]time.Duration{"ri_": 24 * time.Hour, "rt_": 2 * time.Hour}}
+	if str := s.String(); str != `{"ri_":"24h0m0s","rt_":"2h0m0s"}` {
+		t.Errorf("unexpected String() %s", str)
+	}
+}
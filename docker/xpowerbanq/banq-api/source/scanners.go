@@ -2,9 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 )
 
+var (
+	// errNoData is returned by scanners of single records if there are no rows
+	errNoData = errors.New("no data")
+	// errQueryFailed wraps errors of executing (rather than scanning) a query
+	errQueryFailed = errors.New("query failed")
+	// errQueryTimeout is returned by queries exceeding their route's timeout
+	errQueryTimeout = errors.New("query timed out")
+	// errQueryCanceled is returned by queries canceled by their client
+	errQueryCanceled = errors.New("query canceled")
+)
+
 // scanDailyAverage scans a DailyAverage result from a database row
 func scanDailyAverage(rows *sql.Rows) (interface{}, error) {
 	// Pre-allocate slice with maxRows capacity to avoid reallocations
@@ -42,3 +54,126 @@ func scanDailyOHLC(rows *sql.Rows) (interface{}, error) {
 
 	return results, nil
 }
+
+// scanDailyRate scans a DailyRate result from a database row, annualizing
+// the growth between the first and last index of the day
+func scanDailyRate(rows *sql.Rows) (interface{}, error) {
+	// Pre-allocate slice with maxRows capacity to avoid reallocations
+	results := make([]DailyRate, 0, maxRows)
+	for rows.Next() {
+		var dr DailyRate
+		var firstStamp, lastStamp int64
+		if err := rows.Scan(&dr.FirstIndex, &dr.LastIndex, &firstStamp, &lastStamp, &dr.Day, &dr.N); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		apr, apy, err := annualize(dr.FirstIndex, dr.LastIndex, lastStamp-firstStamp)
+		if err != nil {
+			return nil, fmt.Errorf("rate error: %w", err)
+		}
+		dr.APR, dr.APY = apr, apy
+		results = append(results, dr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanIntervalOHLC scans an IntervalOHLC result from a database row
+func scanIntervalOHLC(rows *sql.Rows) (interface{}, error) {
+	// Pre-allocate slice with maxRows capacity to avoid reallocations
+	results := make([]IntervalOHLC, 0, maxRows)
+	for rows.Next() {
+		var ohlc IntervalOHLC
+		if err := rows.Scan(&ohlc.Open, &ohlc.High, &ohlc.Low, &ohlc.Close, &ohlc.Time, &ohlc.N); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, ohlc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanRateEvents scans RateEvent results from database rows
+func scanRateEvents(rows *sql.Rows) (interface{}, error) {
+	// Pre-allocate slice with maxRows capacity to avoid reallocations
+	results := make([]RateEvent, 0, maxRows)
+	for rows.Next() {
+		var ev RateEvent
+		if err := rows.Scan(
+			&ev.BlockNumber, &ev.LogIndex, &ev.TxHash, &ev.ID,
+			&ev.Util, &ev.UtilWad, &ev.Index, &ev.IndexRay, &ev.Stamp,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, ev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanQuoteEvents scans QuoteEvent results from database rows
+func scanQuoteEvents(rows *sql.Rows) (interface{}, error) {
+	// Pre-allocate slice with maxRows capacity to avoid reallocations
+	results := make([]QuoteEvent, 0, maxRows)
+	for rows.Next() {
+		var ev QuoteEvent
+		if err := rows.Scan(
+			&ev.BlockNumber, &ev.LogIndex, &ev.TxHash, &ev.ID,
+			&ev.Bid, &ev.BidWei, &ev.Ask, &ev.AskWei, &ev.QuoteTime,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, ev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanLatestQuote scans the single LatestQuote result from database rows
+func scanLatestQuote(rows *sql.Rows) (interface{}, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %w", err)
+		}
+		return nil, errNoData
+	}
+
+	var quote LatestQuote
+	if err := rows.Scan(&quote.Bid, &quote.Ask, &quote.Mid, &quote.QuoteTime); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return quote, nil
+}
+
+// scanLatestRate scans the single LatestRate result from database rows
+func scanLatestRate(rows *sql.Rows) (interface{}, error) {
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error: %w", err)
+		}
+		return nil, errNoData
+	}
+
+	var rate LatestRate
+	if err := rows.Scan(&rate.Util, &rate.Index, &rate.Stamp); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return rate, nil
+}
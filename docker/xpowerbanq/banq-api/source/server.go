@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// shutdownKey is the context key of a server's shutdown channel
+type shutdownKey struct{}
+
+// newServer creates an HTTP server with the configured timeouts; requests can
+// watch serverShutdown to end long-lived responses when it shuts down
+func newServer(addr string, handler http.Handler) *http.Server {
+	shutdown := make(chan struct{})
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), shutdownKey{}, (<-chan struct{})(shutdown))
+		},
+	}
+	var once sync.Once
+	srv.RegisterOnShutdown(func() {
+		once.Do(func() { close(shutdown) })
+	})
+	return srv
+}
+
+// serverShutdown returns a channel closed when the server of a request shuts
+// down (nil, i.e. never closed, outside newServer)
+func serverShutdown(r *http.Request) <-chan struct{} {
+	shutdown, _ := r.Context().Value(shutdownKey{}).(<-chan struct{})
+	return shutdown
+}
+
+// serve runs the servers until the context is done (e.g. on SIGTERM) or one of
+// them fails, then drains in-flight requests within shutdownTimeout and
+// closes all pooled databases
+func serve(ctx context.Context, servers ...*http.Server) error {
+	failed := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			slog.Info("Starting server", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				failed <- err
+			}
+		}(srv)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", shutdownTimeout)
+	case err = <-failed:
+		slog.Error("Server failed", "err", err)
+	}
+
+	// Drain in-flight requests, then force close the remaining connections
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(drainCtx); shutdownErr != nil {
+			slog.Warn("Forcing server close", "addr", srv.Addr, "err", shutdownErr)
+			srv.Close()
+		}
+	}
+
+	closeDatabases()
+	slog.Info("Shutdown complete")
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// freeAddr returns a currently unused loopback address
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitListening waits until a server accepts connections
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server on %s not listening", addr)
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	srv := newServer(":0", http.NotFoundHandler())
+	if srv.ReadTimeout != readTimeout || srv.ReadHeaderTimeout != readTimeout {
+		t.Errorf("unexpected read timeouts %v, %v", srv.ReadTimeout, srv.ReadHeaderTimeout)
+	}
+	if srv.WriteTimeout != writeTimeout {
+		t.Errorf("unexpected write timeout %v", srv.WriteTimeout)
+	}
+	if srv.IdleTimeout != idleTimeout {
+		t.Errorf("unexpected idle timeout %v", srv.IdleTimeout)
+	}
+}
+
+func TestServeDrainsAndClosesDatabases(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "rt_test_shutdown",
+		testQuoteLine("q1", "1000000000000000000", "2000000000000000000", 1763197200, 100, 0),
+	)
+	db, _, release, err := getDatabase("rt_test_shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// A slow in-flight request and an open stream
+	started := make(chan struct{}, 2)
+	r := chi.NewRouter()
+	r.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-serverShutdown(r)
+	})
+
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, newServer(addr, r)) }()
+	waitListening(t, addr)
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	stream, err := http.Get("http://" + addr + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	<-started
+	<-started
+
+	// Shut down while both requests are in flight
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected clean shutdown, got %v", err)
+		}
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("serve did not return after shutdown")
+	}
+
+	if body := <-slow; body != "done" {
+		t.Errorf("expected in-flight request to complete, got %q", body)
+	}
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Errorf("expected stream to end cleanly, got %v", err)
+	}
+
+	// Pooled databases are closed and evicted
+	if err := db.Ping(); err == nil {
+		t.Error("expected pooled database to be closed")
+	}
+	dbMux.RLock()
+	n := len(dbPool)
+	dbMux.RUnlock()
+	if n != 0 {
+		t.Errorf("expected empty pool, got %d databases", n)
+	}
+}
+
+func TestServeDrainTimeout(t *testing.T) {
+	origShutdownTimeout := shutdownTimeout
+	shutdownTimeout = 100 * time.Millisecond
+	defer func() { shutdownTimeout = origShutdownTimeout }()
+
+	// A request outliving the drain deadline
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, newServer(addr, handler)) }()
+	waitListening(t, addr)
+
+	go http.Get("http://" + addr + "/")
+	<-started
+
+	begin := time.Now()
+	cancel()
+	select {
+	case <-served:
+		if elapsed := time.Since(begin); elapsed < shutdownTimeout {
+			t.Errorf("serve returned before drain deadline after %v", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve did not force close after drain deadline")
+	}
+}
+
+func TestServeFailure(t *testing.T) {
+	// Occupy the address so ListenAndServe fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	err = serve(context.Background(), newServer(l.Addr().String(), http.NotFoundHandler()))
+	if err == nil {
+		t.Error("expected error for occupied address")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingFlags lists the long and short flag names of all settings; the long
+// names are the keys of the config file and, in upper snake case with the
+// BANQ_API_ prefix, the names of environment variables
+var settingFlags = []struct{ long, short string }{
+	{"max-rows", "R"},
+	{"db-path", "P"},
+	{"port", "p"},
+	{"metrics-addr", "M"},
+	{"log-format", "L"},
+	{"read-timeout", "r"},
+	{"write-timeout", "w"},
+	{"idle-timeout", "i"},
+	{"shutdown-timeout", "s"},
+	{"query-timeout", "q"},
+	{"watch-interval", "W"},
+	{"max-staleness", "S"},
+	{"queries-dir", "Q"},
+	{"cache-size", "C"},
+	{"cache-ttl", "T"},
+	{"rate-limit", "l"},
+	{"rate-burst", "b"},
+	{"trusted-proxies", "t"},
+	{"api-keys", "k"},
+	{"cors-origins", "O"},
+}
+
+// envPrefix prefixes the environment variables of settings
+const envPrefix = "BANQ_API_"
+
+// envName returns the environment variable of a setting (e.g. max-rows ->
+// BANQ_API_MAX_ROWS)
+func envName(setting string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// applySettings sets the flags not given on the command line from environment
+// variables and then from the config file, so that flags take precedence over
+// the environment, the environment over the config file, and the config file
+// over the defaults
+func applySettings(fs *flag.FlagSet, configPath string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Config file path from flag or environment
+	if !explicit["c"] && !explicit["config"] {
+		configPath = os.Getenv(envName("config"))
+	}
+
+	settings := make(map[string]string)
+	sources := make(map[string]string)
+	if configPath != "" {
+		values, err := loadConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+		for setting, value := range values {
+			settings[setting] = value
+			sources[setting] = configPath
+		}
+	}
+	for _, s := range settingFlags {
+		if value, ok := os.LookupEnv(envName(s.long)); ok {
+			settings[s.long] = value
+			sources[s.long] = envName(s.long)
+		}
+	}
+
+	for _, s := range settingFlags {
+		value, ok := settings[s.long]
+		if !ok || explicit[s.long] || explicit[s.short] {
+			continue
+		}
+		if err := fs.Set(s.long, value); err != nil {
+			return fmt.Errorf("invalid %s from %s: %v", s.long, sources[s.long], err)
+		}
+	}
+	return nil
+}
+
+// loadConfigFile reads a YAML config file into setting values in flag syntax
+// (lists and maps, i.e. cors-origins and max-staleness, as JSON)
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	known := make(map[string]bool, len(settingFlags))
+	for _, s := range settingFlags {
+		known[s.long] = true
+	}
+
+	settings := make(map[string]string, len(values))
+	for setting, value := range values {
+		if !known[setting] {
+			return nil, fmt.Errorf("unknown setting %s in config file %s", setting, path)
+		}
+		switch value.(type) {
+		case nil:
+			return nil, fmt.Errorf("missing value of %s in config file %s", setting, path)
+		case []interface{}, map[string]interface{}:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s in config file %s: %v", setting, path, err)
+			}
+			settings[setting] = string(data)
+		default:
+			settings[setting] = fmt.Sprint(value)
+		}
+	}
+	return settings, nil
+}
+
+// effectiveConfig is the configuration printed by --print-config (in the
+// config file format)
+type effectiveConfig struct {
+	MaxRows         int               `yaml:"max-rows"`
+	DBPath          string            `yaml:"db-path"`
+	Port            string            `yaml:"port"`
+	MetricsAddr     string            `yaml:"metrics-addr"`
+	LogFormat       string            `yaml:"log-format"`
+	ReadTimeout     string            `yaml:"read-timeout"`
+	WriteTimeout    string            `yaml:"write-timeout"`
+	IdleTimeout     string            `yaml:"idle-timeout"`
+	ShutdownTimeout string            `yaml:"shutdown-timeout"`
+	QueryTimeout    string            `yaml:"query-timeout"`
+	WatchInterval   string            `yaml:"watch-interval"`
+	MaxStaleness    map[string]string `yaml:"max-staleness"`
+	QueriesDir      string            `yaml:"queries-dir"`
+	CacheSize       int               `yaml:"cache-size"`
+	CacheTTL        string            `yaml:"cache-ttl"`
+	RateLimit       float64           `yaml:"rate-limit"`
+	RateBurst       int               `yaml:"rate-burst"`
+	TrustedProxies  []string          `yaml:"trusted-proxies"`
+	APIKeys         string            `yaml:"api-keys"`
+	CORSOrigins     []string          `yaml:"cors-origins"`
+}
+
+// printConfig writes the effective configuration as YAML
+func printConfig(w io.Writer) error {
+	staleness := make(map[string]string, len(maxStaleness))
+	for prefix, threshold := range maxStaleness {
+		staleness[prefix] = threshold.String()
+	}
+	origins := getAllowedOriginsSlice()
+	sort.Strings(origins)
+
+	data, err := yaml.Marshal(effectiveConfig{
+		MaxRows:         maxRows,
+		DBPath:          dbPath,
+		Port:            listenPort,
+		MetricsAddr:     metricsAddr,
+		LogFormat:       logFormat,
+		ReadTimeout:     readTimeout.String(),
+		WriteTimeout:    writeTimeout.String(),
+		IdleTimeout:     idleTimeout.String(),
+		ShutdownTimeout: shutdownTimeout.String(),
+		QueryTimeout:    queryTimeout.String(),
+		WatchInterval:   watchInterval.String(),
+		MaxStaleness:    staleness,
+		QueriesDir:      queriesPath,
+		CacheSize:       cacheSize,
+		CacheTTL:        cacheTTL.String(),
+		RateLimit:       rateLimit,
+		RateBurst:       rateBurst,
+		TrustedProxies:  networkStrings(trustedProxies),
+		APIKeys:         apiKeysPath,
+		CORSOrigins:     origins,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restoreConfig restores all configuration variables, os.Args and the
+// command-line flags after the test
+func restoreConfig(t *testing.T) {
+	origMaxRows, origDbPath, origListenPort, origMetricsAddr := maxRows, dbPath, listenPort, metricsAddr
+	origLogFormat, origWatchInterval := logFormat, watchInterval
+	origReadTimeout, origWriteTimeout := readTimeout, writeTimeout
+	origIdleTimeout, origShutdownTimeout, origQueryTimeout := idleTimeout, shutdownTimeout, queryTimeout
+	origCacheSize, origCacheTTL := cacheSize, cacheTTL
+	origAllowedOrigins, origMaxStaleness, origQueriesPath := allowedOrigins, maxStaleness, queriesPath
+	origRateLimit, origRateBurst, origTrustedProxies := rateLimit, rateBurst, trustedProxies
+	origAPIKeysPath := apiKeysPath
+	origArgs := os.Args
+
+	t.Cleanup(func() {
+		maxRows, dbPath, listenPort, metricsAddr = origMaxRows, origDbPath, origListenPort, origMetricsAddr
+		logFormat, watchInterval = origLogFormat, origWatchInterval
+		readTimeout, writeTimeout = origReadTimeout, origWriteTimeout
+		idleTimeout, shutdownTimeout, queryTimeout = origIdleTimeout, origShutdownTimeout, origQueryTimeout
+		cacheSize, cacheTTL = origCacheSize, origCacheTTL
+		allowedOrigins, maxStaleness, queriesPath = origAllowedOrigins, origMaxStaleness, origQueriesPath
+		rateLimit, rateBurst, trustedProxies = origRateLimit, origRateBurst, origTrustedProxies
+		apiKeysPath = origAPIKeysPath
+		os.Args = origArgs
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	})
+}
+
+// writeConfigFile writes a config file into a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "banq-api.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvName(t *testing.T) {
+	if name := envName("max-rows"); name != "BANQ_API_MAX_ROWS" {
+		t.Errorf("expected BANQ_API_MAX_ROWS, got %s", name)
+	}
+	if name := envName("cors-origins"); name != "BANQ_API_CORS_ORIGINS" {
+		t.Errorf("expected BANQ_API_CORS_ORIGINS, got %s", name)
+	}
+}
+
+func TestParseArgs_Precedence(t *testing.T) {
+	restoreConfig(t)
+
+	path := writeConfigFile(t, `
+max-rows: 50
+port: "9000"
+log-format: json
+cache-ttl: 5m
+cors-origins:
+  - https://file.example.com
+max-staleness:
+  rt_: 30m
+`)
+	t.Setenv("BANQ_API_PORT", "9001")
+	t.Setenv("BANQ_API_CACHE_SIZE", "0")
+	t.Setenv("BANQ_API_CORS_ORIGINS", `["https://env.example.com"]`)
+
+	os.Args = []string{"cmd", "--config", path, "-p", "9002"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	// Flags > environment > config file > defaults
+	if listenPort != "9002" {
+		t.Errorf("listenPort = %v, want 9002 (flag)", listenPort)
+	}
+	if cacheSize != 0 {
+		t.Errorf("cacheSize = %v, want 0 (environment)", cacheSize)
+	}
+	if len(allowedOrigins) != 1 || !allowedOrigins["https://env.example.com"] {
+		t.Errorf("allowedOrigins = %v, want environment origins", allowedOrigins)
+	}
+	if maxRows != 50 || logFormat != "json" || cacheTTL != 5*time.Minute {
+		t.Errorf("maxRows, logFormat, cacheTTL = %v, %v, %v, want 50, json, 5m (config file)", maxRows, logFormat, cacheTTL)
+	}
+	if maxStaleness["rt_"] != 30*time.Minute || maxStaleness["ri_"] != 24*time.Hour {
+		t.Errorf("maxStaleness = %v, want rt_ from config file and ri_ default", maxStaleness)
+	}
+	if dbPath != "/srv/db" {
+		t.Errorf("dbPath = %v, want /srv/db (default)", dbPath)
+	}
+}
+
+func TestParseArgs_ShortFlagPrecedence(t *testing.T) {
+	restoreConfig(t)
+
+	t.Setenv("BANQ_API_CONFIG", writeConfigFile(t, "max-rows: 50\n"))
+	t.Setenv("BANQ_API_DB_PATH", "/env/db")
+
+	os.Args = []string{"cmd", "-R", "10"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	parseArgs()
+
+	if maxRows != 10 {
+		t.Errorf("maxRows = %v, want 10 (short flag)", maxRows)
+	}
+	if dbPath != "/env/db" {
+		t.Errorf("dbPath = %v, want /env/db (environment)", dbPath)
+	}
+}
+
+func TestApplySettingsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		env     string
+		wantErr string
+	}{
+		{"unknown setting", "max-row: 10\n", "", "unknown setting max-row"},
+		{"missing value", "max-rows:\n", "", "missing value of max-rows"},
+		{"invalid YAML", "max-rows: [\n", "", "invalid config file"},
+		{"invalid file value", "max-rows: many\n", "", "invalid max-rows from"},
+		{"invalid environment value", "", "many", "invalid max-rows from BANQ_API_MAX_ROWS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			rows := fs.Int("R", 90, "")
+			fs.IntVar(rows, "max-rows", 90, "")
+
+			var path string
+			if tt.config != "" {
+				path = writeConfigFile(t, tt.config)
+				t.Setenv("BANQ_API_CONFIG", path)
+			}
+			if tt.env != "" {
+				t.Setenv("BANQ_API_MAX_ROWS", tt.env)
+			}
+
+			err := applySettings(fs, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Missing config file
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("c", "", "")
+	fs.Parse([]string{"-c", "/nonexistent/banq-api.yaml"})
+	if err := applySettings(fs, "/nonexistent/banq-api.yaml"); err == nil {
+		t.Error("expected error for missing config file")
+	}
+}
+
+func TestPrintConfigRoundTrip(t *testing.T) {
+	restoreConfig(t)
+
+	var out bytes.Buffer
+	if err := printConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every setting is printed
+	for _, s := range settingFlags {
+		if !strings.Contains(out.String(), s.long+":") {
+			t.Errorf("printed config missing %s:\n%s", s.long, out.String())
+		}
+	}
+
+	// The printed config is a valid config file reproducing the configuration
+	os.Args = []string{"cmd", "--config", writeConfigFile(t, out.String())}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	parseArgs()
+
+	var again bytes.Buffer
+	if err := printConfig(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != out.String() {
+		t.Errorf("config changed in round trip:\n%s\nvs.\n%s", out.String(), again.String())
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// streamRecord is a raw event with its raw_logs rowid (the SSE event ID)
+type streamRecord struct {
+	Seq     int64
+	Event   interface{} // RateEvent, QuoteEvent or EventLog (of a removed log)
+	Removed bool        // log of a block orphaned by a chain reorg
+}
+
+// handleStream streams newly ingested raw events as Server-Sent Events,
+// resuming after the Last-Event-ID (a raw_logs rowid) if given; logs of
+// orphaned blocks are retracted with removed events
+func handleStream(w http.ResponseWriter, r *http.Request, config *RouteConfig) {
+	// Extract database name from URL parameter
+	dbName := chi.URLParam(r, "dbName")
+
+	// Validate database name prefix (and select variant)
+	variant, err := routeVariant(dbName, config)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse removed logs option and resume position
+	includeRemoved, err := includeRemovedFrom(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var lastID int64 = -1
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		lastID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || lastID < 0 {
+			writeError(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get database from pool (held per query only, so the stream follows
+	// replaced databases and does not keep evicted ones open)
+	db, dbFileName, release, err := getDatabase(dbName)
+	if err != nil {
+		requestLog(r).Error("Database error", "db", dbName, "err", err)
+		writeError(w, "Database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		release()
+		writeError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Without a resume position, tail from the current end
+	if lastID < 0 {
+		err = db.QueryRow(streamTailSQL).Scan(&lastID)
+	}
+	release()
+	if err != nil {
+		requestLog(r).Error("Query error", "db", dbName, "err", err)
+		writeError(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+
+	// Streams outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
+	w.Header().Set("X-Database", dbFileName)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamPollInterval.Milliseconds()*5)
+	flusher.Flush()
+
+	poll := time.NewTicker(streamPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	var lastState dbState
+	retracted := make(map[int64]bool) // rowids sent as removed events
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverShutdown(r):
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-poll.C:
+			// Skip querying unless the database (or its WAL) changed
+			state, err := databaseState(dbName)
+			if err != nil {
+				requestLog(r).Error("Database error", "db", dbName, "err", err)
+				return
+			}
+			if state == lastState {
+				continue
+			}
+
+			records, tombstoned, err := tailRecords(r.Context(), dbName, variant, lastID)
+			if errors.Is(err, errQueryCanceled) {
+				requestLog(r).Info("Query canceled", "db", dbName, "err", err)
+				return
+			}
+			if err != nil {
+				requestLog(r).Error("Stream error", "db", dbName, "err", err)
+				return
+			}
+
+			// Retract tombstoned logs before the logs of their new blocks
+			// (without IDs, as they precede the resume position)
+			for _, record := range tombstoned {
+				if retracted[record.Seq] {
+					continue
+				}
+				retracted[record.Seq] = true
+				if err := writeStreamRecord(w, record, false); err != nil {
+					requestLog(r).Error("Stream error", "db", dbName, "err", err)
+					return
+				}
+			}
+			for _, record := range records {
+				if record.Removed {
+					retracted[record.Seq] = true
+					if !includeRemoved {
+						record.Event = eventLogOf(record.Event)
+					}
+				}
+				if err := writeStreamRecord(w, record, true); err != nil {
+					requestLog(r).Error("Stream error", "db", dbName, "err", err)
+					return
+				}
+				lastID = record.Seq
+			}
+			pruneRetracted(retracted, streamMaxRetracted)
+			if len(records) > 0 || len(tombstoned) > 0 {
+				flusher.Flush()
+			}
+			// Drain backlogs page by page before waiting for changes
+			if len(records) < maxRows {
+				lastState = state
+			}
+		}
+	}
+}
+
+// tailRecords queries up to maxRows stream records after the given rowid
+// (including removed logs) from the pooled database, and the removed logs up
+// to the rowid in the blocks of those records, i.e. the logs tombstoned by a
+// reorg to these blocks
+func tailRecords(ctx context.Context, dbName string, config *RouteConfig, lastID int64) (records, tombstoned []streamRecord, err error) {
+	db, _, release, err := getDatabase(dbName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	results, err := runQuery(ctx, db, dbName, config, []interface{}{lastID, maxRows})
+	if err != nil {
+		return nil, nil, err
+	}
+	records = results.([]streamRecord)
+	if len(records) == 0 {
+		return records, nil, nil
+	}
+
+	minBlock, maxBlock := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, record := range records {
+		block := eventLogOf(record.Event).BlockNumber
+		minBlock, maxBlock = min(minBlock, block), max(maxBlock, block)
+	}
+	removedConfig := &RouteConfig{SQL: streamRemovedSQL, ResultScanner: scanRemovedStream, Timeout: config.Timeout}
+	results, err = runQuery(ctx, db, dbName, removedConfig, []interface{}{minBlock, maxBlock, lastID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, results.([]streamRecord), nil
+}
+
+// pruneRetracted forgets the lowest rowids of the removed events beyond the
+// given number (so that a long-lived stream may repeat an old retraction)
+func pruneRetracted(retracted map[int64]bool, limit int) {
+	if len(retracted) <= limit {
+		return
+	}
+	seqs := make([]int64, 0, len(retracted))
+	for seq := range retracted {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs[:len(seqs)-limit] {
+		delete(retracted, seq)
+	}
+}
+
+// writeStreamRecord writes a stream record as SSE event, with its rowid as
+// event ID if requested; removed logs are removed events (with their full
+// payload if included, else with their origin only)
+func writeStreamRecord(w io.Writer, record streamRecord, withID bool) error {
+	data, err := json.Marshal(record.Event)
+	if err != nil {
+		return err
+	}
+	if withID {
+		fmt.Fprintf(w, "id: %d\n", record.Seq)
+	}
+	name := streamEventName(record.Event)
+	if record.Removed {
+		name = "removed"
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return nil
+}
+
+// streamEventName returns the SSE event name of a raw event
+func streamEventName(event interface{}) string {
+	switch event.(type) {
+	case RateEvent:
+		return "rate"
+	case QuoteEvent:
+		return "quote"
+	default:
+		return "message"
+	}
+}
+
+// eventLogOf returns the on-chain origin of a raw event
+func eventLogOf(event interface{}) EventLog {
+	switch ev := event.(type) {
+	case RateEvent:
+		return ev.EventLog
+	case QuoteEvent:
+		return ev.EventLog
+	case EventLog:
+		return ev
+	default:
+		return EventLog{}
+	}
+}
+
+// scanRateStream scans stream records of RateEvent results from database rows
+func scanRateStream(rows *sql.Rows) (interface{}, error) {
+	results := make([]streamRecord, 0, maxRows)
+	for rows.Next() {
+		var seq int64
+		var ev RateEvent
+		var removed bool
+		if err := rows.Scan(
+			&seq, &ev.BlockNumber, &ev.LogIndex, &ev.TxHash, &ev.ID,
+			&ev.Util, &ev.UtilWad, &ev.Index, &ev.IndexRay, &ev.Stamp, &removed,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, streamRecord{Seq: seq, Event: ev, Removed: removed})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanQuoteStream scans stream records of QuoteEvent results from database rows
+func scanQuoteStream(rows *sql.Rows) (interface{}, error) {
+	results := make([]streamRecord, 0, maxRows)
+	for rows.Next() {
+		var seq int64
+		var ev QuoteEvent
+		var removed bool
+		if err := rows.Scan(
+			&seq, &ev.BlockNumber, &ev.LogIndex, &ev.TxHash, &ev.ID,
+			&ev.Bid, &ev.BidWei, &ev.Ask, &ev.AskWei, &ev.QuoteTime, &removed,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, streamRecord{Seq: seq, Event: ev, Removed: removed})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
+
+// scanRemovedStream scans stream records of removed logs from database rows
+func scanRemovedStream(rows *sql.Rows) (interface{}, error) {
+	results := []streamRecord{}
+	for rows.Next() {
+		var seq int64
+		var ev EventLog
+		if err := rows.Scan(&seq, &ev.BlockNumber, &ev.LogIndex, &ev.TxHash, &ev.ID); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		results = append(results, streamRecord{Seq: seq, Event: ev, Removed: true})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// sseEvent is a parsed Server-Sent Event (or heartbeat comment)
+type sseEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// readEvents parses Server-Sent Events from a response body into a channel
+func readEvents(body *bufio.Scanner) <-chan sseEvent {
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		for body.Scan() {
+			line := body.Text()
+			switch {
+			case line == "":
+				if ev != (sseEvent{}) {
+					events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				ev.Comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				ev.ID = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				ev.Event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				ev.Data = line[6:]
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent waits for the next data event, skipping heartbeats
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed")
+			}
+			if ev.Data != "" {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for event")
+		}
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_stream",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+	)
+
+	origPoll, origHeartbeat := streamPollInterval, streamHeartbeat
+	streamPollInterval, streamHeartbeat = 10*time.Millisecond, 20*time.Millisecond
+	defer func() { streamPollInterval, streamHeartbeat = origPoll, origHeartbeat }()
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	connect := func(lastEventID string) (<-chan sseEvent, *http.Response, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ri_test_stream/stream", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		return readEvents(bufio.NewScanner(resp.Body)), resp, cancel
+	}
+
+	// Resuming from the start replays stored events
+	events, resp, cancel := connect("0")
+	defer cancel()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+	ev := nextEvent(t, events)
+	var rate RateEvent
+	if err := json.Unmarshal([]byte(ev.Data), &rate); err != nil {
+		t.Fatalf("failed to parse event data: %v", err)
+	}
+	if ev.ID != "1" || ev.Event != "rate" || rate.ID != "r1" || rate.Util != 0.5 || rate.Stamp != "2025-11-15 09:00:00" {
+		t.Errorf("unexpected event: %+v %+v", ev, rate)
+	}
+
+	// Tailing without resume position only sends new events
+	tailEvents, _, tailCancel := connect("")
+	defer tailCancel()
+
+	// Heartbeats keep idle streams alive
+	select {
+	case hb := <-tailEvents:
+		if hb.Comment != "heartbeat" {
+			t.Errorf("expected heartbeat, got %+v", hb)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for heartbeat")
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "ri_test_stream.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO raw_logs(id, json) VALUES('r2', ?)",
+		testRateLine("r2", "600000000000000000", "1000000000000000000000000000", 1763197300, 101, 0))
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to insert row: %v", err)
+	}
+
+	if ev := nextEvent(t, events); ev.ID != "2" || !strings.Contains(ev.Data, `"id":"r2"`) {
+		t.Errorf("expected resumed stream to receive r2, got %+v", ev)
+	}
+	if ev := nextEvent(t, tailEvents); ev.ID != "2" || !strings.Contains(ev.Data, `"id":"r2"`) {
+		t.Errorf("expected tailing stream to receive r2 first, got %+v", ev)
+	}
+}
+
+func TestHandleStreamReorg(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_stream_reorg",
+		testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+		testRateLine("r2", "600000000000000000", "1000000000000000000000000000", 1763197300, 101, 0),
+	)
+
+	origPoll := streamPollInterval
+	streamPollInterval = 10 * time.Millisecond
+	defer func() { streamPollInterval = origPoll }()
+
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connect := func(query string) <-chan sseEvent {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ri_test_stream_reorg/stream"+query, nil)
+		req.Header.Set("Last-Event-ID", "2")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		return readEvents(bufio.NewScanner(resp.Body))
+	}
+	events, debugEvents := connect(""), connect("?include_removed=1")
+
+	// Block 101 (hash 0x65) is replaced by a reorg with hash 0xca, and the
+	// node reports r1 as removed
+	db, err := openIngestDatabase(filepath.Join(tempDir, "ri_test_stream_reorg.db"), ingestKinds["ri"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		strings.Replace(testRateLine("r3", "700000000000000000", "1000000000000000000000000000", 1763197300, 101, 0),
+			`"blockHash":"0x65"`, `"blockHash":"0xca"`, 1),
+		strings.Replace(testRateLine("r1", "500000000000000000", "1000000000000000000000000000", 1763197200, 100, 0),
+			`"removed":false`, `"removed":true`, 1),
+	}, "\n")
+	_, err = ingest(context.Background(), db, ingestKinds["ri"], strings.NewReader(input),
+		ingestOptions{batchSize: 100, batchInterval: time.Minute})
+	db.Close()
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+
+	// The tombstoned r2 is retracted (without ID) before the log of its new block
+	if ev := nextEvent(t, events); ev.ID != "" || ev.Event != "removed" || !strings.Contains(ev.Data, `"id":"r2"`) {
+		t.Errorf("expected removed event of r2, got %+v", ev)
+	}
+	if ev := nextEvent(t, events); ev.ID != "3" || ev.Event != "rate" || !strings.Contains(ev.Data, `"id":"r3"`) {
+		t.Errorf("expected rate event of r3, got %+v", ev)
+	}
+	// Removal notices are sent as removed events (with ID)
+	ev := nextEvent(t, events)
+	var log EventLog
+	if err := json.Unmarshal([]byte(ev.Data), &log); err != nil {
+		t.Fatalf("failed to parse event data: %v", err)
+	}
+	if ev.ID != "4" || ev.Event != "removed" || log.ID != "r1" || log.BlockNumber != 100 || strings.Contains(ev.Data, "util") {
+		t.Errorf("expected removed event of r1, got %+v", ev)
+	}
+
+	// Included removed logs are removed events too, with their full payload
+	if ev := nextEvent(t, debugEvents); ev.ID != "" || ev.Event != "removed" || !strings.Contains(ev.Data, `"id":"r2"`) {
+		t.Errorf("expected removed event of r2, got %+v", ev)
+	}
+	if ev := nextEvent(t, debugEvents); ev.ID != "3" || ev.Event != "rate" {
+		t.Errorf("expected rate event of r3, got %+v", ev)
+	}
+	if ev := nextEvent(t, debugEvents); ev.ID != "4" || ev.Event != "removed" || !strings.Contains(ev.Data, `"util":0.5`) {
+		t.Errorf("expected removed event of r1 with payload, got %+v", ev)
+	}
+}
+
+func TestPruneRetracted(t *testing.T) {
+	retracted := map[int64]bool{5: true, 1: true, 9: true, 3: true}
+	pruneRetracted(retracted, 4)
+	if len(retracted) != 4 {
+		t.Errorf("expected no pruning within the limit, got %v", retracted)
+	}
+	pruneRetracted(retracted, 2)
+	if len(retracted) != 2 || !retracted[5] || !retracted[9] {
+		t.Errorf("expected the highest rowids to be kept, got %v", retracted)
+	}
+}
+
+func TestHandleStreamErrors(t *testing.T) {
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+
+	tests := []struct {
+		name           string
+		path           string
+		lastEventID    string
+		expectedStatus int
+	}{
+		{"wrong prefix", "/xx_test/stream", "", http.StatusBadRequest},
+		{"invalid Last-Event-ID", "/ri_test/stream", "abc", http.StatusBadRequest},
+		{"negative Last-Event-ID", "/ri_test/stream", "-1", http.StatusBadRequest},
+		{"database not found", "/ri_nonexistent/stream", "", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "database/sql"
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
 
 // RouteConfig defines the configuration for an API endpoint
 type RouteConfig struct {
@@ -8,8 +12,41 @@ type RouteConfig struct {
 	SQL           string   // SQL query to execute
 	QueryParams   []string // e.g., ["lhs", "rhs"] - required query parameters
 	ResultScanner func(rows *sql.Rows) (interface{}, error)
-	Description   string // Human-readable description for API docs
-	Example       string // Example path for API docs
+	Description   string        // Human-readable description for API docs
+	Example       string        // Example path for API docs
+	CacheMaxAge   int           // Cache-Control max-age in seconds (default: 3600)
+	Response      interface{}   // Sample response (e.g. []DailyAverage{}) for API docs
+	MediaType     string        // Response media type for API docs (default: application/json)
+	NamedArgs     bool          // Bind query parameters by name (e.g. :lhs) and the row limit to :limit
+	Timeout       time.Duration // Maximum query duration (default: queryTimeout)
+	Cost          int           // Rate limit tokens taken per request (default: 1)
+
+	// Variants are alternative configs selected by database name prefix
+	// (e.g. one for "ri_" and one for "rt_" databases)
+	Variants []*RouteConfig
+	// Handler is an optional custom handler (default: handleEndpoint)
+	Handler func(w http.ResponseWriter, r *http.Request, config *RouteConfig)
+	// IncludeRemoved documents that a custom Handler reads include_removed
+	// (as the default handler always does)
+	IncludeRemoved bool
+}
+
+// ParamConfig defines how a query parameter is parsed and documented
+type ParamConfig struct {
+	Parse    func(r *http.Request, paramName string) (interface{}, error)
+	Format   string                 // Human-readable format for API docs
+	Optional bool                   // Whether the parameter may be omitted
+	Schema   map[string]interface{} // JSON Schema for the OpenAPI spec
+}
+
+// dated is implemented by results that belong to a calendar day
+type dated interface {
+	date() string // YYYY-MM-DD, or YYYY-MM-DD HH:MM:SS of intraday rows
+}
+
+// stamped is implemented by single records that carry a data timestamp
+type stamped interface {
+	stamp() time.Time
 }
 
 // DailyAverage represents daily average utilization rate data
@@ -29,6 +66,103 @@ type DailyOHLC struct {
 	N     int      `json:"n"`
 }
 
+func (da DailyAverage) date() string { return da.Day }
+
+func (ohlc DailyOHLC) date() string { return ohlc.Day }
+
+// DailyRate represents the daily annualized rate derived from index growth
+type DailyRate struct {
+	APR        *float64 `json:"apr"`
+	APY        *float64 `json:"apy"`
+	FirstIndex string   `json:"first_index_ray"`
+	LastIndex  string   `json:"last_index_ray"`
+	Day        string   `json:"day"`
+	N          int      `json:"n"`
+}
+
+func (dr DailyRate) date() string { return dr.Day }
+
+// IntervalOHLC represents OHLC (Open-High-Low-Close) price quote data per candle interval
+type IntervalOHLC struct {
+	Open  *float64 `json:"open"`
+	High  float64  `json:"high"`
+	Low   float64  `json:"low"`
+	Close *float64 `json:"close"`
+	Time  string   `json:"time"`
+	N     int      `json:"n"`
+}
+
+func (ohlc IntervalOHLC) date() string { return ohlc.Time }
+
+// LatestQuote represents the most recent price quote of an rt_ database
+type LatestQuote struct {
+	Bid       float64 `json:"bid"`
+	Ask       float64 `json:"ask"`
+	Mid       float64 `json:"mid"`
+	QuoteTime string  `json:"quote_time"`
+}
+
+func (q LatestQuote) stamp() time.Time { return parseStamp(q.QuoteTime) }
+
+// LatestRate represents the most recent utilization rate of an ri_ database
+type LatestRate struct {
+	Util  float64 `json:"util"`
+	Index float64 `json:"index"`
+	Stamp string  `json:"stamp"`
+}
+
+func (r LatestRate) stamp() time.Time { return parseStamp(r.Stamp) }
+
+// parseStamp parses an SQLite datetime (YYYY-MM-DD HH:MM:SS, UTC)
+func parseStamp(value string) time.Time {
+	t, _ := time.Parse(time.DateTime, value)
+	return t
+}
+
+// Envelope wraps results with their range and whether maxRows truncated them
+type Envelope struct {
+	LHS       string      `json:"lhs"`
+	RHS       string      `json:"rhs"`
+	Count     int         `json:"count"`
+	Truncated bool        `json:"truncated"`
+	NextLHS   *string     `json:"next_lhs"` // lhs to continue from if truncated
+	Results   interface{} `json:"results"`
+}
+
+// EventLog represents the on-chain origin of a raw event
+type EventLog struct {
+	BlockNumber int64  `json:"block_number"`
+	LogIndex    int64  `json:"log_index"`
+	TxHash      string `json:"tx_hash"`
+	ID          string `json:"id"`
+}
+
+// RateEvent represents a raw utilization rate event of an ri_ database
+type RateEvent struct {
+	EventLog
+	Util     float64 `json:"util"`
+	UtilWad  string  `json:"util_wad"`
+	Index    float64 `json:"index"`
+	IndexRay string  `json:"index_ray"`
+	Stamp    string  `json:"stamp"`
+}
+
+// QuoteEvent represents a raw price quote event of an rt_ database
+type QuoteEvent struct {
+	EventLog
+	Bid       float64 `json:"bid"`
+	BidWei    string  `json:"bid_wei"`
+	Ask       float64 `json:"ask"`
+	AskWei    string  `json:"ask_wei"`
+	QuoteTime string  `json:"quote_time"`
+}
+
+// EventPage represents a page of raw events with a link to the next page
+type EventPage struct {
+	Events interface{} `json:"events"`
+	Next   *string     `json:"next"`
+}
+
 // ErrorResponse represents an error response returned by the API
 type ErrorResponse struct {
 	Error string `json:"error"`
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runVerify runs the verify subcommand, which reports the coverage of a
+// database and exits with 1 if it found issues (2 on usage or database errors)
+func runVerify(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+
+	kindPtr := flags.String("k", "", "Kind of logs (ri or rt)")
+	flags.StringVar(kindPtr, "kind", "", "Kind of logs (ri or rt)")
+
+	gapFactorPtr := flags.Float64("G", coverageGapFactor, "Suspicious gap in multiples of the median blocks between events")
+	flags.Float64Var(gapFactorPtr, "gap-factor", coverageGapFactor, "Suspicious gap in multiples of the median blocks between events")
+
+	jsonPtr := flags.Bool("j", false, "Print the report as JSON")
+	flags.BoolVar(jsonPtr, "json", false, "Print the report as JSON")
+
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify [options] <db>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Report block ranges, suspicious gaps, duplicate events and empty days of a database\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "  -k, --kind string\n")
+		fmt.Fprintf(os.Stderr, "        Kind of logs, ri or rt (default: by database name prefix)\n")
+		fmt.Fprintf(os.Stderr, "  -G, --gap-factor float\n")
+		fmt.Fprintf(os.Stderr, "        Suspicious gap in multiples of the median blocks between events (default: %g)\n", coverageGapFactor)
+		fmt.Fprintf(os.Stderr, "  -j, --json\n")
+		fmt.Fprintf(os.Stderr, "        Print the report as JSON\n")
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	if *gapFactorPtr <= 0 {
+		fmt.Fprintln(os.Stderr, "gap factor must be positive")
+		return 2
+	}
+
+	path := flags.Arg(0)
+	kind, err := databaseKind(*kindPtr, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// Open read-only, so that a missing database is not created
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer db.Close()
+	if err := checkSchema(db); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", path, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	coverage, err := coverageOf(ctx, db, coverageSQL[kind+"_"], *gapFactorPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", path, err)
+		return 2
+	}
+
+	if *jsonPtr {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(coverage)
+	} else {
+		printCoverage(stdout, path, coverage)
+	}
+	if coverage.issues() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printCoverage prints a coverage report as text
+func printCoverage(w io.Writer, path string, coverage Coverage) {
+	fmt.Fprintf(w, "Database: %s\n", path)
+	fmt.Fprintf(w, "Logs:     %d (%d removed)\n", coverage.Logs, coverage.Removed)
+	fmt.Fprintf(w, "Cadence:  %d blocks between events (median)\n", coverage.Cadence)
+
+	fmt.Fprintf(w, "\nBlock ranges: %d\n", len(coverage.Ranges))
+	for _, r := range coverage.Ranges {
+		fmt.Fprintf(w, "  %d-%d  %s to %s  (%d logs)\n",
+			r.FromBlock, r.ToBlock, orUnknown(r.From), orUnknown(r.To), r.Logs)
+	}
+	fmt.Fprintf(w, "\nSuspicious gaps: %d\n", len(coverage.Gaps))
+	for _, g := range coverage.Gaps {
+		fmt.Fprintf(w, "  %d-%d  %s to %s  (%d blocks, %ds)\n",
+			g.FromBlock, g.ToBlock, orUnknown(g.From), orUnknown(g.To), g.Blocks, g.Seconds)
+	}
+	fmt.Fprintf(w, "\nDuplicate events: %d\n", len(coverage.Duplicates))
+	for _, d := range coverage.Duplicates {
+		fmt.Fprintf(w, "  %s#%d  ids %s\n", d.TxHash, d.LogIndex, strings.Join(d.IDs, ", "))
+	}
+	fmt.Fprintf(w, "\nEmpty days: %d\n", len(coverage.EmptyDays))
+	for _, day := range coverage.EmptyDays {
+		fmt.Fprintf(w, "  %s\n", day)
+	}
+}
+
+// orUnknown returns a time, or "?" for logs without one
+func orUnknown(value *string) string {
+	if value == nil {
+		return "?"
+	}
+	return *value
+}
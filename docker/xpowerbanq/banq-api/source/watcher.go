@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// databaseChanges lists the databases added, removed or replaced in dbPath
+type databaseChanges struct {
+	Added    []string
+	Removed  []string
+	Replaced []string
+}
+
+var (
+	// Known ri_ and rt_ database files (by database name)
+	watchedFiles = make(map[string]os.FileInfo)
+	watchedMux   sync.RWMutex
+)
+
+// scanDatabases updates the known database files from dbPath and returns
+// the changes since the last scan; a file is replaced if it is no longer the
+// same file (e.g. moved over the old one or a retargeted symlink)
+func scanDatabases() (databaseChanges, error) {
+	paths, err := filepath.Glob(filepath.Join(dbPath, "*.db"))
+	if err != nil {
+		return databaseChanges{}, fmt.Errorf("failed to list database files: %v", err)
+	}
+
+	found := make(map[string]os.FileInfo, len(paths))
+	for _, path := range paths {
+		dbName := strings.TrimSuffix(filepath.Base(path), ".db")
+		if catalogPrefix(dbName) == "" {
+			continue
+		}
+		info, err := os.Stat(path) // follows symlinks
+		if err != nil {
+			continue // removed while scanning (or dangling symlink)
+		}
+		found[dbName] = info
+	}
+
+	watchedMux.Lock()
+	defer watchedMux.Unlock()
+
+	var changes databaseChanges
+	for dbName, info := range found {
+		known, exists := watchedFiles[dbName]
+		if !exists {
+			changes.Added = append(changes.Added, dbName)
+		} else if !os.SameFile(known, info) {
+			changes.Replaced = append(changes.Replaced, dbName)
+		}
+	}
+	for dbName := range watchedFiles {
+		if _, exists := found[dbName]; !exists {
+			changes.Removed = append(changes.Removed, dbName)
+		}
+	}
+	watchedFiles = found
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Replaced)
+	return changes, nil
+}
+
+// knownDatabases returns the sorted names of the known database files
+func knownDatabases() []string {
+	watchedMux.RLock()
+	defer watchedMux.RUnlock()
+
+	dbNames := make([]string, 0, len(watchedFiles))
+	for dbName := range watchedFiles {
+		dbNames = append(dbNames, dbName)
+	}
+	sort.Strings(dbNames)
+	return dbNames
+}
+
+// rescanDatabases scans dbPath, evicts the connection pools and catalog
+// entries of removed and replaced databases, and logs all changes
+func rescanDatabases() {
+	changes, err := scanDatabases()
+	if err != nil {
+		slog.Error("Database scan error", "path", dbPath, "err", err)
+		return
+	}
+
+	for _, dbName := range changes.Added {
+		slog.Info("Database added", "db", dbName)
+	}
+	for _, dbName := range changes.Removed {
+		slog.Info("Database removed", "db", dbName)
+		evictDatabase(dbName)
+		forgetCatalog(dbName)
+	}
+	for _, dbName := range changes.Replaced {
+		slog.Info("Database replaced", "db", dbName)
+		evictDatabase(dbName)
+		forgetCatalog(dbName)
+	}
+}
+
+// watchDatabases rescans dbPath every interval (if positive) and whenever
+// rescan receives (e.g. on SIGHUP) until the context is done
+func watchDatabases(ctx context.Context, interval time.Duration, rescan <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			rescanDatabases()
+		case <-rescan:
+			slog.Info("Rescanning databases", "path", dbPath)
+			rescanDatabases()
+		}
+	}
+}
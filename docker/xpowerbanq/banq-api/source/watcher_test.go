@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// resetWatchedFiles clears the known database files for the test's duration
+func resetWatchedFiles(t *testing.T) {
+	watchedMux.Lock()
+	orig := watchedFiles
+	watchedFiles = make(map[string]os.FileInfo)
+	watchedMux.Unlock()
+	t.Cleanup(func() {
+		watchedMux.Lock()
+		watchedFiles = orig
+		watchedMux.Unlock()
+	})
+}
+
+// pooled reports whether a database has a pooled connection
+func pooled(dbName string) bool {
+	dbMux.RLock()
+	defer dbMux.RUnlock()
+	_, exists := dbPool[dbName]
+	return exists
+}
+
+func TestScanDatabases(t *testing.T) {
+	resetWatchedFiles(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_a")
+	createTestDatabase(t, tempDir, "rt_test_b")
+	// Not an ri_ or rt_ database
+	os.WriteFile(filepath.Join(tempDir, "other.db"), nil, 0644)
+
+	changes, err := scanDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := databaseChanges{Added: []string{"ri_test_a", "rt_test_b"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	// Unchanged directory
+	if changes, _ := scanDatabases(); !reflect.DeepEqual(changes, databaseChanges{}) {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+
+	// Replace rt_test_b (moved over) and remove ri_test_a
+	createTestDatabase(t, tempDir, "rt_test_c")
+	if err := os.Rename(filepath.Join(tempDir, "rt_test_c.db"), filepath.Join(tempDir, "rt_test_b.db")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(tempDir, "ri_test_a.db"))
+
+	changes, err = scanDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = databaseChanges{Removed: []string{"ri_test_a"}, Replaced: []string{"rt_test_b"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+	if dbNames := knownDatabases(); !reflect.DeepEqual(dbNames, []string{"rt_test_b"}) {
+		t.Errorf("expected known databases [rt_test_b], got %v", dbNames)
+	}
+}
+
+func TestRescanDatabasesEvictsPools(t *testing.T) {
+	resetWatchedFiles(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "rt_test_old",
+		testQuoteLine("q1", "1000000000000000000", "2000000000000000000", 1763197200, 100, 0),
+	)
+	createTestDatabase(t, tempDir, "rt_test_new",
+		testQuoteLine("q1", "3000000000000000000", "4000000000000000000", 1763197200, 100, 0),
+	)
+	rescanDatabases()
+
+	if _, err := listDatabases(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, release, err := getDatabase("rt_test_old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// Replace rt_test_old with rt_test_new
+	if err := os.Rename(filepath.Join(tempDir, "rt_test_new.db"), filepath.Join(tempDir, "rt_test_old.db")); err != nil {
+		t.Fatal(err)
+	}
+	rescanDatabases()
+
+	if pooled("rt_test_old") {
+		t.Error("expected pool of replaced database to be evicted")
+	}
+	catalogMux.Lock()
+	_, cataloged := catalog["rt_test_old"]
+	catalogMux.Unlock()
+	if cataloged {
+		t.Error("expected catalog entry of replaced database to be dropped")
+	}
+
+	// Queries see the replacement
+	req := httptest.NewRequest(http.MethodGet, "/rt_test_old/latest.json", nil)
+	rr := httptest.NewRecorder()
+	r := chi.NewRouter()
+	registerAPIRoutes(r)
+	r.ServeHTTP(rr, req)
+	var quote LatestQuote
+	if err := json.NewDecoder(rr.Body).Decode(&quote); err != nil {
+		t.Fatal(err)
+	}
+	if quote.Bid != 3 {
+		t.Errorf("expected bid of replacement 3, got %v", quote.Bid)
+	}
+}
+
+func TestWatchDatabasesOnSignal(t *testing.T) {
+	resetWatchedFiles(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_watch")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rescan := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		watchDatabases(ctx, 0, rescan)
+		close(done)
+	}()
+
+	rescan <- syscall.SIGHUP
+	rescan <- syscall.SIGHUP // returns once the first rescan completed
+	if dbNames := knownDatabases(); !reflect.DeepEqual(dbNames, []string{"ri_test_watch"}) {
+		t.Errorf("expected known databases [ri_test_watch], got %v", dbNames)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not stop")
+	}
+}
+
+func TestHandleRootListsDatabases(t *testing.T) {
+	resetWatchedFiles(t)
+	tempDir := t.TempDir()
+	createTestDatabase(t, tempDir, "ri_test_root")
+	rescanDatabases()
+
+	rr := httptest.NewRecorder()
+	handleRoot(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var response struct {
+		Databases []string `json:"databases"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.Databases, []string{"ri_test_root"}) {
+		t.Errorf("expected databases [ri_test_root], got %v", response.Databases)
+	}
+}
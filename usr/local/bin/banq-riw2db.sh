@@ -64,6 +64,11 @@ CREATE INDEX IF NOT EXISTS idx_stamp
   ON raw_logs (CAST(REPLACE(json_extract(json,'$.stamp'),'n','') AS INTEGER));
 SQL
 
+# --- Typed columns served by the API (no-op once migrated) ---
+if command -v banq-api >/dev/null 2>&1; then
+  banq-api migrate --kind=ri "$DB_PATH"
+fi
+
 # --- Long-lived ingest connection over a dedicated FD (no stdout pipe) ---
 exec 3> >(sqlite3 "$DB_PATH" >/dev/null 2>&1)
 